@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+var applyCommand = &cli.Command{
+	Name:  "apply",
+	Usage: "Bring the fireplace to a desired state",
+	Description: `Reads the fireplace's status, sends only the commands needed to reach the
+desired state in a safe order, confirms the result and reports what changed.
+
+The desired state can be given as flags, as a YAML or JSON file with the keys
+power, target_temperature, flame_effect and fan_boost, or both, in which case
+flags take precedence.`,
+	Flags: append(targetFlags(), desiredStateFlags...),
+	Action: func(c *cli.Context) error {
+		desired, err := desiredStateFromFlags(c)
+		if err != nil {
+			return err
+		}
+
+		fp, err := fireplaceFromFlags(c)
+		if err != nil {
+			return err
+		}
+
+		result, err := fp.Apply(desired)
+		if result != nil && result.After != nil {
+			if printErr := printResult(c, newApplyResult(fp, result, c.Bool("dry-run"))); printErr != nil {
+				return printErr
+			}
+		}
+		return err
+	},
+}
+
+// desiredStateFlags describe a desired state, read by desiredStateFromFlags.
+var desiredStateFlags = []cli.Flag{
+	&cli.PathFlag{
+		Name:    "file",
+		Aliases: []string{"f"},
+		Usage:   "YAML or JSON file describing the desired state, - for stdin",
+	},
+	&cli.StringFlag{
+		Name:  "power",
+		Usage: "Desired power, on or off",
+	},
+	&cli.IntFlag{
+		Name:  "target-temp",
+		Usage: "Desired target temperature",
+	},
+	&cli.StringFlag{
+		Name:  "flame-effect",
+		Usage: "Desired flame effect, on or off",
+	},
+	&cli.StringFlag{
+		Name:  "fan-boost",
+		Usage: "Desired fan boost, on or off",
+	},
+}
+
+func desiredStateFromFlags(c *cli.Context) (firecontrol.DesiredState, error) {
+	var desired firecontrol.DesiredState
+
+	if c.IsSet("file") {
+		var (
+			b   []byte
+			err error
+		)
+		if c.Path("file") == "-" {
+			b, err = io.ReadAll(os.Stdin)
+		} else {
+			b, err = os.ReadFile(c.Path("file"))
+		}
+		if err != nil {
+			return desired, invalidInput(err)
+		}
+		// JSON is valid YAML, so one decoder handles both.
+		if err := yaml.Unmarshal(b, &desired); err != nil {
+			return desired, invalidInput(fmt.Errorf("parsing %s: %w", c.Path("file"), err))
+		}
+	}
+
+	switches := []struct {
+		flag  string
+		value **bool
+	}{
+		{"power", &desired.Power},
+		{"flame-effect", &desired.FlameEffect},
+		{"fan-boost", &desired.FanBoost},
+	}
+	for _, sw := range switches {
+		if !c.IsSet(sw.flag) {
+			continue
+		}
+		on, err := parseOnOff(c.String(sw.flag))
+		if err != nil {
+			return desired, invalidInput(fmt.Errorf("--%s: %w", sw.flag, err))
+		}
+		*sw.value = &on
+	}
+
+	if c.IsSet("target-temp") {
+		temp := c.Int("target-temp")
+		desired.TargetTemperature = &temp
+	}
+
+	if desired == (firecontrol.DesiredState{}) {
+		return desired, invalidInput(fmt.Errorf("no desired state given"))
+	}
+	if err := desired.Validate(); err != nil {
+		return desired, invalidInput(err)
+	}
+	return desired, nil
+}
+
+type applyResult struct {
+	IP      string             `json:"ip" yaml:"ip"`
+	Changes []firecontrol.Step `json:"changes" yaml:"changes"`
+	Status  statusResult       `json:"status" yaml:"status"`
+	DryRun  bool               `json:"dry_run" yaml:"dry_run"`
+}
+
+func newApplyResult(fp *firecontrol.Fireplace, result *firecontrol.ApplyResult, dryRun bool) applyResult {
+	return applyResult{
+		IP:      fp.Addr.IP.String(),
+		Changes: result.Steps,
+		Status:  statusResultFor(fp.Addr.IP.String(), result.After),
+		DryRun:  dryRun,
+	}
+}
+
+func (r applyResult) text(w io.Writer) {
+	suffix := ""
+	if r.DryRun {
+		suffix = " (dry run)"
+	}
+	if len(r.Changes) == 0 {
+		fmt.Fprintf(w, "%s: already in the desired state%s\n", r.IP, suffix)
+		return
+	}
+	fmt.Fprintf(w, "%s: applied %d change(s)%s\n", r.IP, len(r.Changes), suffix)
+	for _, change := range r.Changes {
+		fmt.Fprintf(w, "\t%s: %s -> %s\n", change.Setting, change.From, change.To)
+	}
+}
+
+func (r applyResult) table() ([]string, [][]string) {
+	rows := make([][]string, 0, len(r.Changes))
+	for _, change := range r.Changes {
+		rows = append(rows, []string{r.IP, change.Setting, change.From, change.To})
+	}
+	return []string{"IP", "SETTING", "FROM", "TO"}, rows
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/config"
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
+	"github.com/sourcegraph/conc/pool"
+	"github.com/urfave/cli/v2"
+)
+
+// errPartialFailure is returned when a batch failed on some but not all of its
+// fireplaces.
+var errPartialFailure = errors.New("some fireplaces failed")
+
+// batchFlags returns the target flags plus the flags that select several
+// fireplaces at once.
+func batchFlags() []cli.Flag {
+	return append(targetFlags(),
+		&cli.BoolFlag{
+			Name:  "all",
+			Usage: "Act on every configured fireplace, or every fireplace on the network if none are configured",
+		},
+		&cli.StringFlag{
+			Name:  "group",
+			Usage: "Act on every fireplace in the named group from the configuration file",
+		},
+	)
+}
+
+// isBatch reports whether the command should act on several fireplaces.
+func isBatch(c *cli.Context) bool {
+	return c.Bool("all") || c.IsSet("group")
+}
+
+// batchTarget is a fireplace selected by --all or --group, or the reason it
+// could not be found.
+type batchTarget struct {
+	name      string
+	fireplace *firecontrol.Fireplace
+	err       error
+}
+
+func (t batchTarget) ip() string {
+	if t.fireplace == nil {
+		return ""
+	}
+	return t.fireplace.Addr.IP.String()
+}
+
+// batchTargetsFromFlags resolves the fireplaces selected by --all or --group.
+func batchTargetsFromFlags(c *cli.Context) ([]batchTarget, error) {
+	if c.Bool("all") && c.IsSet("group") {
+		return nil, invalidInput(errors.New("--all and --group cannot be used together"))
+	}
+	if c.IsSet("ip") || c.IsSet("fireplace") {
+		return nil, invalidInput(errors.New("--ip and --fireplace cannot be used with --all or --group"))
+	}
+
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.IsSet("group") {
+		// Empty groups are an error, so they never fall back to every
+		// fireplace below.
+		names, err := cfg.Group(c.String("group"))
+		if err != nil {
+			return nil, invalidInput(err)
+		}
+		return locateBatchTargets(c, cfg, names), nil
+	}
+
+	names := cfg.FireplaceNames()
+	if len(names) == 0 {
+		// Without configured fireplaces --all acts on every one on the network.
+		fs, err := fireplacesFromFlags(c)
+		if err != nil {
+			return nil, err
+		}
+		targets := make([]batchTarget, 0, len(fs))
+		for _, fp := range fs {
+			targets = append(targets, batchTarget{name: fp.Addr.IP.String(), fireplace: fp})
+		}
+		return targets, nil
+	}
+	return locateBatchTargets(c, cfg, names), nil
+}
+
+// locateBatchTargets returns a target for each of the named fireplaces.
+func locateBatchTargets(c *cli.Context, cfg *config.Config, names []string) []batchTarget {
+	fs, errs := cfg.LocateEach(names, fireplaceOptions(c)...)
+	targets := make([]batchTarget, 0, len(names))
+	for i, name := range names {
+		targets = append(targets, batchTarget{name: name, fireplace: fs[i], err: errs[i]})
+	}
+	return targets
+}
+
+// runBatch runs action concurrently against every fireplace selected by --all
+// or --group and prints a result for each. It returns errPartialFailure if only
+// some of the fireplaces failed.
+func runBatch(c *cli.Context, action string, temperature *int, run func(fp *firecontrol.Fireplace) (*statusResult, error)) error {
+	targets, err := batchTargetsFromFlags(c)
+	if err != nil {
+		return err
+	}
+
+	results := make(batchResults, len(targets))
+	errs := make([]error, len(targets))
+
+	p := pool.New()
+	for i, target := range targets {
+		results[i] = batchResult{
+			Fireplace:   target.name,
+			IP:          target.ip(),
+			Action:      action,
+			Temperature: temperature,
+			DryRun:      c.Bool("dry-run"),
+		}
+		if target.err != nil {
+			errs[i] = target.err
+			continue
+		}
+
+		p.Go(func() {
+			results[i].Status, errs[i] = run(target.fireplace)
+		})
+	}
+	p.Wait()
+
+	failed := 0
+	for i, err := range errs {
+		results[i].OK = err == nil
+		if err != nil {
+			results[i].Error = err.Error()
+			failed++
+		}
+	}
+
+	if err := printResult(c, results); err != nil {
+		return err
+	}
+
+	switch {
+	case failed == 0:
+		return nil
+	case failed == len(targets):
+		return fmt.Errorf("%s failed on every fireplace: %w", action, errors.Join(errs...))
+	default:
+		return fmt.Errorf("%w: %s failed on %d of %d fireplaces", errPartialFailure, action, failed, len(targets))
+	}
+}
+
+// batchResult is the outcome of a command on one of several fireplaces.
+type batchResult struct {
+	Fireplace   string        `json:"fireplace" yaml:"fireplace"`
+	IP          string        `json:"ip" yaml:"ip"`
+	Action      string        `json:"action" yaml:"action"`
+	Temperature *int          `json:"temperature,omitempty" yaml:"temperature,omitempty"`
+	DryRun      bool          `json:"dry_run" yaml:"dry_run"`
+	OK          bool          `json:"ok" yaml:"ok"`
+	Error       string        `json:"error,omitempty" yaml:"error,omitempty"`
+	Status      *statusResult `json:"status,omitempty" yaml:"status,omitempty"`
+}
+
+type batchResults []batchResult
+
+func (r batchResults) text(w io.Writer) {
+	for _, result := range r {
+		label := result.Fireplace
+		if result.IP != "" && result.IP != result.Fireplace {
+			label = fmt.Sprintf("%s (%s)", result.Fireplace, result.IP)
+		}
+
+		switch {
+		case !result.OK:
+			fmt.Fprintf(w, "%s: error: %s\n", label, result.Error)
+		case result.Status != nil:
+			fmt.Fprintf(w, "%s: %s\n", label, describeStatus(*result.Status))
+		default:
+			fmt.Fprintf(w, "%s: %s\n", label, commandMessage(result.Action, result.Temperature, result.DryRun))
+		}
+	}
+}
+
+func (r batchResults) table() ([]string, [][]string) {
+	header := []string{"FIREPLACE", "IP", "ACTION", "RESULT", "POWER", "FLAME EFFECT", "FAN BOOST", "TARGET", "ROOM"}
+	rows := make([][]string, 0, len(r))
+	for _, result := range r {
+		outcome := "ok"
+		if !result.OK {
+			outcome = "error: " + result.Error
+		}
+		row := []string{result.Fireplace, result.IP, result.Action, outcome, "", "", "", "", ""}
+		if s := result.Status; s != nil {
+			copy(row[4:], []string{
+				formatBoolean(s.Power),
+				formatBoolean(s.FlameEffect),
+				formatBoolean(s.FanBoost),
+				fmt.Sprintf("%dºC", s.TargetTemperature),
+				fmt.Sprintf("%dºC", s.RoomTemperature),
+			})
+		}
+		rows = append(rows, row)
+	}
+	return header, rows
+}
+
+// describeStatus summarises a status on one line.
+func describeStatus(s statusResult) string {
+	return fmt.Sprintf("Power %s, Flame Effect %s, Fan Boost %s, Target %dºC, Room %dºC",
+		formatBoolean(s.Power),
+		formatBoolean(s.FlameEffect),
+		formatBoolean(s.FanBoost),
+		s.TargetTemperature,
+		s.RoomTemperature,
+	)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
+)
+
+const batchConfig = `
+fireplaces:
+  lounge:
+    serial: 1
+    pin: 1
+    ip: 10.0.0.40
+  study:
+    serial: 2
+    pin: 2
+    ip: 10.0.0.41
+  den:
+    serial: 3
+    pin: 3
+    ip: 10.0.0.42
+groups:
+  downstairs: [lounge, den]
+  upstairs: []
+  outside: [lounge, patio]
+`
+
+// runBatchApp runs a batch command against batchConfig with args, calling run
+// for every fireplace selected. It returns the fireplaces that were run, the
+// results printed and the command's error.
+func runBatchApp(t *testing.T, run func(fp *firecontrol.Fireplace) (*statusResult, error), args ...string) ([]string, batchResults, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(batchConfig), 0o600))
+
+	var (
+		mu  sync.Mutex
+		ran []string
+		out bytes.Buffer
+	)
+	app := &cli.App{
+		Writer:    &out,
+		ErrWriter: io.Discard,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "dry-run"},
+			&cli.PathFlag{Name: "config", Value: path},
+			&cli.StringFlag{Name: "output", Value: outputJSON},
+		},
+		Commands: []*cli.Command{{
+			Name:  "power-on",
+			Flags: batchFlags(),
+			Action: func(c *cli.Context) error {
+				return runBatch(c, "power-on", nil, func(fp *firecontrol.Fireplace) (*statusResult, error) {
+					mu.Lock()
+					ran = append(ran, fp.Addr.IP.String())
+					mu.Unlock()
+					return run(fp)
+				})
+			},
+		}},
+	}
+
+	err := app.Run(append([]string{"firecontrol", "power-on"}, args...))
+
+	var results batchResults
+	if out.Len() > 0 {
+		require.NoError(t, json.Unmarshal(out.Bytes(), &results))
+	}
+	sort.Strings(ran)
+	return ran, results, err
+}
+
+func succeed(*firecontrol.Fireplace) (*statusResult, error) { return nil, nil }
+
+func TestBatchTargets(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		ran  []string
+		code int
+	}{
+		{"all", []string{"--all"}, []string{"10.0.0.40", "10.0.0.41", "10.0.0.42"}, 0},
+		{"group", []string{"--group", "downstairs"}, []string{"10.0.0.40", "10.0.0.42"}, 0},
+		{"group ignores case", []string{"--group", "DownStairs"}, []string{"10.0.0.40", "10.0.0.42"}, 0},
+		{"empty group", []string{"--group", "upstairs"}, nil, exitInvalidInput},
+		{"unknown group", []string{"--group", "attic"}, nil, exitInvalidInput},
+		{"all and group", []string{"--all", "--group", "downstairs"}, nil, exitInvalidInput},
+		{"group and ip", []string{"--group", "downstairs", "--ip", "10.0.0.40"}, nil, exitInvalidInput},
+		{"all and fireplace", []string{"--all", "--fireplace", "lounge"}, nil, exitInvalidInput},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ran, _, err := runBatchApp(t, succeed, tt.args...)
+			assert.Equal(t, tt.code, exitCode(err), "error: %v", err)
+			assert.Equal(t, tt.ran, ran)
+		})
+	}
+}
+
+func TestBatchFailures(t *testing.T) {
+	failOn := func(ips ...string) func(fp *firecontrol.Fireplace) (*statusResult, error) {
+		return func(fp *firecontrol.Fireplace) (*statusResult, error) {
+			for _, ip := range ips {
+				if fp.Addr.IP.String() == ip {
+					return nil, errors.New("i/o timeout")
+				}
+			}
+			return nil, nil
+		}
+	}
+
+	tests := []struct {
+		name   string
+		args   []string
+		run    func(fp *firecontrol.Fireplace) (*statusResult, error)
+		code   int
+		failed []string
+	}{
+		{"none", []string{"--all"}, succeed, 0, nil},
+		{"some", []string{"--all"}, failOn("10.0.0.41"), exitPartial, []string{"study"}},
+		{"every one", []string{"--group", "downstairs"}, failOn("10.0.0.40", "10.0.0.42"), exitFailure, []string{"lounge", "den"}},
+		{"not configured", []string{"--group", "outside"}, succeed, exitPartial, []string{"patio"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, results, err := runBatchApp(t, tt.run, tt.args...)
+			assert.Equal(t, tt.code, exitCode(err), "error: %v", err)
+
+			var failed []string
+			for _, r := range results {
+				assert.Equal(t, "power-on", r.Action)
+				if !r.OK {
+					assert.NotEmpty(t, r.Error, r.Fireplace)
+					failed = append(failed, r.Fireplace)
+				}
+			}
+			assert.Equal(t, tt.failed, failed)
+		})
+	}
+}
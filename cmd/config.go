@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/urfave/cli/v2"
+)
+
+var configCommand = &cli.Command{
+	Name:  "config",
+	Usage: "Inspect the configuration file",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "check",
+			Usage: "Validate the configuration file",
+			Description: `Reads the configuration file, applies environment overrides and reports every
+problem found. Exits with code 2 if there are any.`,
+			Action: func(c *cli.Context) error {
+				cfg, err := loadConfig(c)
+				if err != nil {
+					return err
+				}
+
+				result := configCheckResult{Path: c.String("config"), Problems: make([]string, 0)}
+				for _, problem := range cfg.Check() {
+					result.Problems = append(result.Problems, problem.Error())
+				}
+				if err := printResult(c, result); err != nil {
+					return err
+				}
+
+				if len(result.Problems) > 0 {
+					return invalidInput(fmt.Errorf("%d problem(s) found in %s", len(result.Problems), result.Path))
+				}
+				return nil
+			},
+		},
+	},
+}
+
+type configCheckResult struct {
+	Path     string   `json:"path" yaml:"path"`
+	Problems []string `json:"problems" yaml:"problems"`
+}
+
+func (r configCheckResult) text(w io.Writer) {
+	if len(r.Problems) == 0 {
+		fmt.Fprintf(w, "%s: OK\n", r.Path)
+		return
+	}
+	fmt.Fprintf(w, "%s:\n", r.Path)
+	for _, problem := range r.Problems {
+		fmt.Fprintf(w, "\t%s\n", problem)
+	}
+}
+
+func (r configCheckResult) table() ([]string, [][]string) {
+	rows := make([][]string, 0, len(r.Problems))
+	for _, problem := range r.Problems {
+		rows = append(rows, []string{r.Path, problem})
+	}
+	return []string{"PATH", "PROBLEM"}, rows
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
+)
+
+// Exit codes returned by firecontrol, so scripts can tell failures apart.
+const (
+	exitFailure       = 1 // Any other failure
+	exitInvalidInput  = 2 // Bad flags, arguments or values
+	exitUnreachable   = 3 // The fireplace did not answer or the network failed
+	exitProtocolError = 4 // The fireplace answered with something we did not expect
+	exitTimeout       = 5 // A condition was not met in time
+	exitPartial       = 6 // A command failed on some of several fireplaces
+)
+
+// errInvalidInput marks errors caused by the user's input.
+var errInvalidInput = errors.New("invalid input")
+
+type inputError struct {
+	err error
+}
+
+func (e *inputError) Error() string { return e.err.Error() }
+func (e *inputError) Unwrap() []error {
+	return []error{e.err, errInvalidInput}
+}
+
+func invalidInput(err error) error {
+	return &inputError{err: err}
+}
+
+// exitCode classifies err into one of the exit codes above.
+func exitCode(err error) int {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errTimeout):
+		return exitTimeout
+	case errors.Is(err, errPartialFailure):
+		return exitPartial
+	case errors.Is(err, errInvalidInput),
+		errors.Is(err, firecontrol.ErrInvalidTemperature),
+		errors.Is(err, firecontrol.ErrDataTooLarge),
+		// urfave/cli does not export its missing flags error
+		strings.HasPrefix(err.Error(), "Required flag"):
+		return exitInvalidInput
+	case errors.Is(err, firecontrol.ErrInvalidResponse),
+		errors.Is(err, firecontrol.ErrUnexpectedResponse):
+		return exitProtocolError
+	case errors.As(err, &netErr), errors.Is(err, os.ErrDeadlineExceeded):
+		return exitUnreachable
+	}
+	return exitFailure
+}
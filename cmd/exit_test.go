@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, 0},
+		{"timeout", fmt.Errorf("waiting: %w", errTimeout), exitTimeout},
+		{"partial failure", fmt.Errorf("%w: power-on failed on 1 of 2 fireplaces", errPartialFailure), exitPartial},
+		{"invalid input", invalidInput(errors.New("--ip or --fireplace is required")), exitInvalidInput},
+		{"invalid temperature", fmt.Errorf("setting: %w", firecontrol.ErrInvalidTemperature), exitInvalidInput},
+		{"data too large", firecontrol.ErrDataTooLarge, exitInvalidInput},
+		{"invalid response", firecontrol.ErrInvalidResponse, exitProtocolError},
+		{"unexpected response", fmt.Errorf("%w: *firecontrol.Status", firecontrol.ErrUnexpectedResponse), exitProtocolError},
+		{"network error", &net.OpError{Op: "read", Net: "udp", Err: os.ErrDeadlineExceeded}, exitUnreachable},
+		{"deadline", os.ErrDeadlineExceeded, exitUnreachable},
+		{"other", errors.New("no fireplaces found"), exitFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, exitCode(tt.err))
+		})
+	}
+}
+
+func TestExitCodeMissingFlags(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags []cli.Flag
+	}{
+		{"one flag", []cli.Flag{
+			&cli.IntFlag{Name: "temp", Required: true},
+		}},
+		{"several flags", []cli.Flag{
+			&cli.IntFlag{Name: "temp", Required: true},
+			&cli.StringFlag{Name: "name", Required: true},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &cli.App{
+				Writer:    io.Discard,
+				ErrWriter: io.Discard,
+				Commands: []*cli.Command{{
+					Name:   "set-temp",
+					Flags:  tt.flags,
+					Action: func(*cli.Context) error { return nil },
+				}},
+			}
+
+			err := app.Run([]string{"firecontrol", "set-temp"})
+			assert.Error(t, err)
+			assert.Equal(t, exitInvalidInput, exitCode(err), "urfave/cli's error %q is invalid input", err)
+		})
+	}
+}
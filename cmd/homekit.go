@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/homekit"
+	"github.com/urfave/cli/v2"
+)
+
+var homekitCommand = &cli.Command{
+	Name:  "homekit",
+	Usage: "Manage the HomeKit accessory",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "pairings",
+			Usage: "Manage the controllers paired with the HomeKit accessory",
+			Description: `Reads the HomeKit store in --storage-dir, or homekit.storage_dir in the
+configuration file. Refuses to run while start-homekit-accessory is using the store
+unless --force is given.`,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "storage-dir",
+					Usage: "Directory pairings are kept in (default: ./db)",
+				},
+				&cli.BoolFlag{
+					Name:  "force",
+					Usage: "Use the store even if a running server holds it",
+				},
+			},
+			Subcommands: []*cli.Command{
+				{
+					Name:  "list",
+					Usage: "List paired controllers",
+					Action: func(c *cli.Context) error {
+						return withPairingStore(c, func(store *homekit.PairingStore) error {
+							pairings, err := store.List()
+							if err != nil {
+								return err
+							}
+
+							results := make(pairingResults, 0, len(pairings))
+							for _, p := range pairings {
+								results = append(results, newPairingResult(p))
+							}
+							return printResult(c, results)
+						})
+					},
+				},
+				{
+					Name:      "remove",
+					Usage:     "Remove a paired controller",
+					ArgsUsage: "<name>",
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							return invalidInput(fmt.Errorf("expected a pairing name"))
+						}
+						return withPairingStore(c, func(store *homekit.PairingStore) error {
+							err := store.Remove(c.Args().First())
+							if errors.Is(err, homekit.ErrPairingNotFound) {
+								return invalidInput(err)
+							}
+							return err
+						})
+					},
+				},
+				{
+					Name:  "reset",
+					Usage: "Remove every pairing and the accessory's identity",
+					Description: `Removes every pairing along with the accessory's keys, so it can be added to
+the Home app again as a new accessory. The setup code is kept.`,
+					Action: func(c *cli.Context) error {
+						return withPairingStore(c, func(store *homekit.PairingStore) error {
+							return store.Reset()
+						})
+					},
+				},
+			},
+		},
+	},
+}
+
+// withPairingStore opens the HomeKit store given by --storage-dir or the
+// configuration file and calls fn with it.
+func withPairingStore(c *cli.Context, fn func(*homekit.PairingStore) error) error {
+	dir := c.String("storage-dir")
+	if dir == "" {
+		cfg, err := loadConfig(c)
+		if err != nil {
+			return err
+		}
+		dir = cfg.HomeKit.StorageDir
+	}
+
+	store, err := homekit.OpenPairingStore(dir, c.Bool("force"))
+	if errors.Is(err, os.ErrNotExist) {
+		return invalidInput(err)
+	}
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return fn(store)
+}
+
+type pairingResult struct {
+	Name      string `json:"name" yaml:"name"`
+	Admin     bool   `json:"admin" yaml:"admin"`
+	PublicKey string `json:"public_key" yaml:"public_key"`
+}
+
+func newPairingResult(p homekit.Pairing) pairingResult {
+	return pairingResult{
+		Name:      p.Name,
+		Admin:     p.Admin,
+		PublicKey: fmt.Sprintf("%x", p.PublicKey),
+	}
+}
+
+type pairingResults []pairingResult
+
+func (r pairingResults) text(w io.Writer) {
+	if len(r) == 0 {
+		fmt.Fprintln(w, "No pairings")
+		return
+	}
+	for _, p := range r {
+		fmt.Fprintf(w, "%s (%s)\n", p.Name, formatPermission(p.Admin))
+	}
+}
+
+func (r pairingResults) table() ([]string, [][]string) {
+	rows := make([][]string, 0, len(r))
+	for _, p := range r {
+		rows = append(rows, []string{p.Name, formatPermission(p.Admin)})
+	}
+	return []string{"NAME", "PERMISSION"}, rows
+}
+
+func formatPermission(admin bool) string {
+	if admin {
+		return "admin"
+	}
+	return "user"
+}
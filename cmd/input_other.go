@@ -0,0 +1,14 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// waitForInput cannot tell whether f has something to read on this platform,
+// so it leaves the read to block.
+func waitForInput(f *os.File, timeout time.Duration) (bool, error) {
+	return true, nil
+}
@@ -0,0 +1,26 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// waitForInput waits up to timeout for f to have something to read. select is
+// used rather than poll as macOS cannot poll terminals.
+func waitForInput(f *os.File, timeout time.Duration) (bool, error) {
+	fd := int(f.Fd())
+	var fds unix.FdSet
+	fds.Set(fd)
+	tv := unix.NsecToTimeval(timeout.Nanoseconds())
+
+	n, err := unix.Select(fd+1, &fds, nil, nil, &tv)
+	if errors.Is(err, unix.EINTR) {
+		return false, nil
+	}
+	return n > 0, err
+}
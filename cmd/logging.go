@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	hapLog "github.com/brutella/hap/log"
+	"github.com/urfave/cli/v2"
+)
+
+// Log formats accepted by --log-format.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// Verbosity levels of the HAP library accepted by --hap-log-level.
+const (
+	hapLogOff   = "off"
+	hapLogInfo  = "info"
+	hapLogDebug = "debug"
+)
+
+// loggingFlags returns the global flags that configure logging.
+func loggingFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "debug",
+			Usage: "Enable debug logging, the same as --log-level debug",
+		},
+		&cli.StringFlag{
+			Name:    "log-level",
+			Usage:   "Minimum level logged: debug, info, warn or error",
+			Value:   "info",
+			EnvVars: []string{"FIRECONTROL_LOG_LEVEL"},
+		},
+		&cli.StringFlag{
+			Name:    "log-format",
+			Usage:   "Log format: text or json",
+			Value:   logFormatText,
+			EnvVars: []string{"FIRECONTROL_LOG_FORMAT"},
+		},
+		&cli.PathFlag{
+			Name:    "log-file",
+			Usage:   "Append logs to this file instead of writing them to stderr",
+			EnvVars: []string{"FIRECONTROL_LOG_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "hap-log-level",
+			Usage:   "Logging from the HomeKit library: off, info or debug, regardless of --log-level",
+			Value:   hapLogInfo,
+			EnvVars: []string{"FIRECONTROL_HAP_LOG_LEVEL"},
+		},
+	}
+}
+
+// setupLogging makes the default logger, and the HAP library's loggers, follow
+// the logging flags. It returns the log file to close on exit, if any.
+func setupLogging(c *cli.Context) (io.Closer, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.String("log-level"))); err != nil {
+		return nil, invalidInput(fmt.Errorf("unknown log level %q, expected debug, info, warn or error", c.String("log-level")))
+	}
+	if c.Bool("debug") && !c.IsSet("log-level") {
+		level = slog.LevelDebug
+	}
+
+	hapLevel := c.String("hap-log-level")
+	switch hapLevel {
+	case hapLogOff, hapLogInfo, hapLogDebug:
+	default:
+		return nil, invalidInput(fmt.Errorf("unknown HAP log level %q, expected off, info or debug", hapLevel))
+	}
+
+	var (
+		w    io.Writer = os.Stderr
+		file *os.File
+	)
+	if path := c.Path("log-file"); path != "" {
+		var err error
+		file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			return nil, invalidInput(fmt.Errorf("opening log file: %w", err))
+		}
+		w = file
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch c.String("log-format") {
+	case logFormatText:
+		handler = slog.NewTextHandler(w, opts)
+	case logFormatJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		if file != nil {
+			file.Close()
+		}
+		return nil, invalidInput(fmt.Errorf("unknown log format %q, expected text or json", c.String("log-format")))
+	}
+	slog.SetDefault(slog.New(handler))
+
+	setupHAPLogger(hapLog.Info, handler, slog.LevelInfo, hapLevel != hapLogOff)
+	setupHAPLogger(hapLog.Debug, handler, slog.LevelDebug, hapLevel == hapLogDebug)
+
+	if file == nil {
+		return nil, nil
+	}
+	return file, nil
+}
+
+// setupHAPLogger sends the lines l logs to handler as records of level if
+// enabled, and discards them otherwise.
+func setupHAPLogger(l *hapLog.Logger, handler slog.Handler, level slog.Level, enabled bool) {
+	if !enabled {
+		l.Disable()
+		return
+	}
+	l.SetPrefix("")
+	l.SetFlags(log.Lshortfile)
+	l.SetOutput(hapWriter{handler: handler, level: level})
+}
+
+// hapWriter turns the lines logged by the HAP library into log records. They
+// are handled whatever the log level, as --hap-log-level already chose which
+// are logged.
+type hapWriter struct {
+	handler slog.Handler
+	level   slog.Level
+}
+
+func (w hapWriter) Write(p []byte) (int, error) {
+	r := slog.NewRecord(time.Now(), w.level, strings.TrimSpace(string(p)), 0)
+	r.AddAttrs(slog.String("component", "hap"))
+	if err := w.handler.Handle(context.Background(), r); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -1,34 +1,57 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"log/slog"
 	"net"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/config"
 	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
 	"github.com/ivanvanderbyl/escea-fireplace/pkg/homekit"
 	"github.com/urfave/cli/v2"
 )
 
 func main() {
-	commonFlags := []cli.Flag{
-		&cli.StringFlag{
-			Name:     "ip",
-			Usage:    "IP address of the fireplace",
-			Required: true,
-		},
-	}
+	// logFile is closed once the command's error, if any, has been logged.
+	var logFile io.Closer
 
 	app := &cli.App{
 		Name:  "firecontrol",
 		Usage: "Remote control for Escea fireplaces",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.BoolFlag{
-				Name:  "debug",
-				Usage: "Enable debug logging, useful for troubleshooting HomeKit accessory issues",
+				Name:  "dry-run",
+				Usage: "Print the commands that would change the fireplace instead of sending them",
+			},
+			&cli.PathFlag{
+				Name:    "config",
+				Usage:   "Path to the configuration file",
+				Value:   config.DefaultPath(),
+				EnvVars: []string{"FIRECONTROL_CONFIG"},
+			},
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "Output format: text, json, yaml or table",
+				Value:   outputText,
+				EnvVars: []string{"FIRECONTROL_OUTPUT"},
 			},
+		}, loggingFlags()...),
+		Before: func(c *cli.Context) error {
+			if err := validateOutputFormat(c.String("output")); err != nil {
+				return err
+			}
+			var err error
+			logFile, err = setupLogging(c)
+			return err
+		},
+		OnUsageError: func(c *cli.Context, err error, isSubcommand bool) error {
+			return invalidInput(err)
 		},
 		Commands: []*cli.Command{
 			{
@@ -42,131 +65,497 @@ func main() {
 					}
 
 					for _, f := range fs {
-						slog.Info("Found Fireplace", "IP", f.Addr, "Serial", f.Serial, "PIN", f.PIN)
+						slog.Debug("Found Fireplace", "IP", f.Addr, "Serial", f.Serial, "PIN", f.PIN)
 					}
-					return nil
+					return printResult(c, newSearchResults(fs))
 				},
 			},
 			{
 				Name:  "status",
 				Usage: "Get the status of a fireplace",
-				Flags: commonFlags,
+				Flags: batchFlags(),
 				Action: func(c *cli.Context) error {
-					addr := net.ParseIP(c.String("ip"))
+					if isBatch(c) {
+						return runBatch(c, "status", nil, func(fp *firecontrol.Fireplace) (*statusResult, error) {
+							if err := fp.Refresh(); err != nil {
+								return nil, err
+							}
+							status := newStatusResult(fp)
+							return &status, nil
+						})
+					}
 
-					fp := firecontrol.NewFireplace(addr)
-					err := fp.Refresh()
+					fp, err := fireplaceFromFlags(c)
+					if err != nil {
+						return err
+					}
+
+					err = fp.Refresh()
 					if err != nil {
 						slog.Error("Failed to refresh fireplace", "error", err)
 						return err
 					}
 
-					fmt.Printf("Fireplace: %s\n\tFire Status: %s\n\tFlame Effect: %s\n\tFan Boost: %s\n\tDesired Temperature: %dºC\n\tRoom Temperature: %dºC\n",
-						fp.Addr.IP.String(),
-						formatBoolean(fp.Status.IsOn),
-						formatBoolean(fp.Status.FlameEffectIsOn),
-						formatBoolean(fp.Status.FanBoostIsOn),
-						fp.Status.TargetTempertaure, fp.Status.CurrentTemperature,
-					)
-					return nil
+					return printResult(c, newStatusResult(fp))
 				},
 			},
 			{
 				Name:  "power-on",
 				Usage: "Power on the fireplace",
-				Flags: commonFlags,
+				Flags: batchFlags(),
 				Action: func(c *cli.Context) error {
-					fmt.Println("Powering on the fireplace...")
+					if isBatch(c) {
+						return runBatch(c, "power-on", nil, func(fp *firecontrol.Fireplace) (*statusResult, error) {
+							return nil, fp.PowerOn()
+						})
+					}
 
-					addr := net.ParseIP(c.String("ip"))
+					fp, err := fireplaceFromFlags(c)
+					if err != nil {
+						return err
+					}
 
-					fp := firecontrol.NewFireplace(addr)
-					err := fp.PowerOn()
+					slog.Debug("Powering on the fireplace", "IP", fp.Addr.IP)
+					err = fp.PowerOn()
 					if err != nil {
 						slog.Error("Failed to power on fireplace", "error", err)
 						return err
 					}
 
-					slog.Info("Fireplace powered on", "IP", c.String("ip"))
-					return nil
+					return printResult(c, commandResult{IP: fp.Addr.IP.String(), Action: "power-on", DryRun: c.Bool("dry-run")})
 				},
 			},
 			{
 				Name:  "power-off",
 				Usage: "Power off the fireplace",
-				Flags: commonFlags,
+				Flags: batchFlags(),
 				Action: func(c *cli.Context) error {
-					fmt.Println("Powering off the fireplace...")
+					if isBatch(c) {
+						return runBatch(c, "power-off", nil, func(fp *firecontrol.Fireplace) (*statusResult, error) {
+							return nil, fp.PowerOff()
+						})
+					}
 
-					addr := net.ParseIP(c.String("ip"))
+					fp, err := fireplaceFromFlags(c)
+					if err != nil {
+						return err
+					}
 
-					fp := firecontrol.NewFireplace(addr)
-					err := fp.PowerOff()
+					slog.Debug("Powering off the fireplace", "IP", fp.Addr.IP)
+					err = fp.PowerOff()
 					if err != nil {
 						slog.Error("Failed to power off fireplace", "error", err)
 						return err
 					}
 
-					slog.Info("Fireplace powered off", "IP", c.String("ip"))
-					return nil
+					return printResult(c, commandResult{IP: fp.Addr.IP.String(), Action: "power-off", DryRun: c.Bool("dry-run")})
 				},
 			},
 			{
 				Name:  "set-temp",
 				Usage: "Set the temperature of the fireplace",
-				Flags: []cli.Flag{
-					&cli.StringFlag{
-						Name:     "ip",
-						Usage:    "IP address of the fireplace",
-						Required: true,
-					},
+				Flags: append(batchFlags(),
 					&cli.IntFlag{
 						Name:     "temp",
 						Usage:    "Temperature to set",
 						Required: true,
 					},
-				},
+				),
 				Action: func(c *cli.Context) error {
-					fmt.Println("Setting temperature...")
+					temp := c.Int("temp")
+					if isBatch(c) {
+						return runBatch(c, "set-temp", &temp, func(fp *firecontrol.Fireplace) (*statusResult, error) {
+							return nil, fp.SetTemperature(temp)
+						})
+					}
 
-					addr := net.ParseIP(c.String("ip"))
+					fp, err := fireplaceFromFlags(c)
+					if err != nil {
+						return err
+					}
 
-					fp := firecontrol.NewFireplace(addr)
-					err := fp.SetTemperature(c.Int("temp"))
+					slog.Debug("Setting temperature", "IP", fp.Addr.IP, "Temperature", temp)
+					err = fp.SetTemperature(temp)
 					if err != nil {
 						slog.Error("Failed to set temperature", "error", err)
 						return err
 					}
 
-					slog.Info("Temperature set", "IP", c.String("ip"), "Temperature", c.Int("temp"))
-					return nil
+					return printResult(c, commandResult{IP: fp.Addr.IP.String(), Action: "set-temp", Temperature: &temp, DryRun: c.Bool("dry-run")})
 				},
 			},
+			rawCommand,
+			watchCommand,
+			tuiCommand,
+			waitForCommand,
+			applyCommand,
+			presetCommand,
+			configCommand,
+			homekitCommand,
 			{
-				Name:        "start-homekit-accessory",
-				Action:      homekit.AccessoryAction,
-				Description: `Starts a HomeKit accessory server for each fireplaaace found on the network.`,
+				Name:   "start-homekit-accessory",
+				Action: homeKitAccessoryAction,
+				Description: `Starts a HomeKit accessory server for the fireplace given by --serial,
+or for the fireplaces listed under homekit in the configuration file.
+
+Fireplaces given by IP address, with --ip or in the configuration file, are not
+searched for. Those not answering yet are shown as not responding while the
+bridge keeps trying to reach them in the background.`,
 				Flags: []cli.Flag{
 					&cli.IntFlag{
 						Name:     "pin",
 						Usage:    "Fireplace PIN, found on inside of remote control",
 						Category: "Escea Fireplace Settings",
-						Required: true,
 					},
 					&cli.IntFlag{
 						Name:     "serial",
 						Usage:    "Fireplace Serial Number, found on inside of remote control",
 						Category: "Escea Fireplace Settings",
-						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "ip",
+						Usage:    "IP address of the fireplace given by --serial, instead of searching the network for it",
+						Category: "Escea Fireplace Settings",
+					},
+					&cli.StringFlag{
+						Name:     "name",
+						Usage:    "Name shown in the Home app for the bridge, or the fireplace when not bridged",
+						Category: "HomeKit Settings",
+					},
+					&cli.StringFlag{
+						Name:     "storage-dir",
+						Usage:    "Directory pairings are kept in (default: ./db)",
+						Category: "HomeKit Settings",
+					},
+					&cli.StringFlag{
+						Name:     "setup-code",
+						Usage:    "8 digit code used to pair, generated and kept in the storage directory if not given",
+						Category: "HomeKit Settings",
+					},
+					&cli.IntFlag{
+						Name:     "port",
+						Usage:    "Port to listen on (default: any free port)",
+						Category: "HomeKit Settings",
+					},
+					&cli.StringFlag{
+						Name:     "interface",
+						Usage:    "Network interface to listen and advertise on (default: all)",
+						Category: "HomeKit Settings",
+					},
+					&cli.BoolFlag{
+						Name:     "temperature-sensor",
+						Usage:    "Add a temperature sensor for the room temperature, for use in automations",
+						Category: "HomeKit Settings",
+					},
+					&cli.StringFlag{
+						Name:     "mode",
+						Usage:    "Service to expose fireplaces as: thermostat or heater-cooler (default: thermostat)",
+						Category: "HomeKit Settings",
 					},
 				},
 			},
 		},
 	}
 
-	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err)
+	err := app.Run(os.Args)
+	if err != nil {
+		slog.Error("Command failed", "error", err)
+	}
+	if logFile != nil {
+		logFile.Close()
+	}
+	if err != nil {
+		os.Exit(exitCode(err))
+	}
+}
+
+// defaultAccessoryName is the name of a fireplace given by --serial.
+const defaultAccessoryName = "Fireplace"
+
+// homeKitAccessoryAction serves the fireplaces to HomeKit until interrupted.
+func homeKitAccessoryAction(c *cli.Context) error {
+	slog.Info("Starting HomeKit accessory")
+
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	settings, err := homeKitSettings(c, cfg.HomeKit)
+	if err != nil {
+		return err
+	}
+
+	names, fireplaces, err := homeKitFireplaces(c, cfg)
+	if err != nil {
+		return err
+	}
+	slog.Info("Found fireplaces", "found-count", len(fireplaces))
+
+	opts := []homekit.BridgeOption{
+		homekit.WithHomeKit(settings),
+		homekit.WithPresets(cfg.Presets),
+		homekit.WithSetupOutput(os.Stdout),
+	}
+	if cfg.Service.RefreshInterval > 0 {
+		opts = append(opts, homekit.WithRefreshInterval(cfg.Service.RefreshInterval))
+	}
+	if cfg.Service.FaultThreshold > 0 {
+		opts = append(opts, homekit.WithFaultThreshold(cfg.Service.FaultThreshold))
+	}
+	for i, fp := range fireplaces {
+		// Fireplaces given by --serial are not in the configuration file and
+		// are assumed to have every capability.
+		settings, _ := cfg.Fireplace(names[i])
+		opts = append(opts, homekit.WithFireplace(names[i], fp, settings))
+	}
+
+	// Stop the bridge on interrupt or SIGTERM.
+	ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return homekit.NewBridge(opts...).Run(ctx)
+}
+
+// homeKitSettings returns the HomeKit settings from the configuration file with
+// the command line flags applied over them.
+func homeKitSettings(c *cli.Context, settings config.HomeKit) (config.HomeKit, error) {
+	if c.IsSet("name") {
+		settings.Name = c.String("name")
+	}
+	if c.IsSet("storage-dir") {
+		settings.StorageDir = c.String("storage-dir")
+	}
+	if c.IsSet("setup-code") {
+		settings.SetupCode = c.String("setup-code")
+	}
+	if c.IsSet("port") {
+		settings.Port = c.Int("port")
+	}
+	if c.IsSet("interface") {
+		settings.Interface = c.String("interface")
+	}
+	if c.IsSet("temperature-sensor") {
+		settings.TemperatureSensor = c.Bool("temperature-sensor")
+	}
+	if c.IsSet("mode") {
+		settings.Mode = c.String("mode")
+	}
+	if !config.ValidHomeKitMode(settings.Mode) {
+		return settings, invalidInput(fmt.Errorf("unknown mode %q, expected %s or %s", settings.Mode, config.HomeKitModeThermostat, config.HomeKitModeHeaterCooler))
+	}
+	return settings, nil
+}
+
+// homeKitFireplaces returns the fireplace given by the --serial flag, or the
+// fireplaces the configuration file exposes to HomeKit, along with the name
+// each is shown with in the Home app.
+func homeKitFireplaces(c *cli.Context, cfg *config.Config) ([]string, []*firecontrol.Fireplace, error) {
+	if !c.IsSet("serial") {
+		names := cfg.HomeKitFireplaces()
+		if len(names) == 0 {
+			return nil, nil, invalidInput(fmt.Errorf("--serial is required when no fireplaces are configured"))
+		}
+		// HomeKit keeps each fireplace's accessory ID and history under its
+		// serial, which a fireplace given only by address has not told us yet.
+		for _, name := range names {
+			if f, err := cfg.Fireplace(name); err == nil && f.Serial == 0 {
+				return nil, nil, invalidInput(fmt.Errorf("fireplaces.%s: serial is required", name))
+			}
+		}
+		return locateHomeKitFireplaces(c, cfg, names)
+	}
+
+	pin := c.Int("pin")
+	serial := c.Int("serial")
+	if serial <= 0 {
+		return nil, nil, invalidInput(fmt.Errorf("invalid serial %d", serial))
+	}
+
+	// Searching relies on broadcasts, which some networks filter. The bridge
+	// checks that the fireplace at a given address is the right one.
+	if c.IsSet("ip") {
+		addr := net.ParseIP(c.String("ip"))
+		if addr == nil {
+			return nil, nil, invalidInput(fmt.Errorf("invalid IP address %q", c.String("ip")))
+		}
+		fp := firecontrol.NewFireplace(addr, fireplaceOptions(c)...)
+		fp.Serial, fp.PIN = uint32(serial), uint16(pin)
+		return []string{defaultAccessoryName}, []*firecontrol.Fireplace{fp}, nil
+	}
+
+	found, err := firecontrol.SearchForFireplaces()
+	if err != nil {
+		return nil, nil, fmt.Errorf("searching for fireplaces: %w", err)
+	}
+
+	// The serial alone identifies the fireplace, as --pin is optional.
+	for _, fireplace := range found {
+		if fireplace.Serial != uint32(serial) {
+			slog.Info("Skipping fireplace", "serial", fireplace.Serial)
+			continue
+		}
+		fireplace.Configure(fireplaceOptions(c)...)
+		return []string{defaultAccessoryName}, []*firecontrol.Fireplace{fireplace}, nil
+	}
+	return nil, nil, fmt.Errorf("no fireplace with serial %d found", serial)
+}
+
+// locateHomeKitFireplaces locates the named fireplaces, returning those found
+// along with their names. A fireplace that has to be searched for may only
+// have missed the broadcast, so one that is not found is left out with a
+// warning rather than keeping the others from HomeKit.
+func locateHomeKitFireplaces(c *cli.Context, cfg *config.Config, names []string) ([]string, []*firecontrol.Fireplace, error) {
+	located, errs := cfg.LocateEach(names, fireplaceOptions(c)...)
+
+	var (
+		found      []string
+		fireplaces []*firecontrol.Fireplace
+	)
+	for i, name := range names {
+		if errs[i] == nil {
+			found = append(found, name)
+			fireplaces = append(fireplaces, located[i])
+			continue
+		}
+		if f, err := cfg.Fireplace(name); err == nil && f.IP == "" {
+			slog.Warn("Fireplace not found, leaving it out of HomeKit until restarted", "fireplace", name, "error", errs[i])
+			continue
+		}
+		// Anything else is a mistake in the configuration file.
+		return nil, nil, invalidInput(errs[i])
+	}
+
+	if len(fireplaces) == 0 {
+		return nil, nil, fmt.Errorf("none of the fireplaces were found: %w", errors.Join(errs...))
+	}
+	return found, fireplaces, nil
+}
+
+// targetFlags returns the flags used to pick a fireplace, either by IP address
+// or by its name in the configuration file.
+func targetFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "ip",
+			Usage:   "IP address of the fireplace",
+			EnvVars: []string{"FIRECONTROL_IP"},
+		},
+		&cli.StringFlag{
+			Name:    "fireplace",
+			Usage:   "Name of the fireplace in the configuration file",
+			EnvVars: []string{"FIRECONTROL_FIREPLACE"},
+		},
+	}
+}
+
+// fireplaceFromFlags returns the fireplace addressed by the --ip or --fireplace
+// flags. If neither is set and the configuration file describes exactly one
+// fireplace, that one is used.
+func fireplaceFromFlags(c *cli.Context) (*firecontrol.Fireplace, error) {
+	if c.IsSet("ip") {
+		addr := net.ParseIP(c.String("ip"))
+		if addr == nil {
+			return nil, invalidInput(fmt.Errorf("invalid IP address %q", c.String("ip")))
+		}
+		return firecontrol.NewFireplace(addr, fireplaceOptions(c)...), nil
+	}
+
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	name := c.String("fireplace")
+	if name == "" {
+		names := cfg.FireplaceNames()
+		if len(names) != 1 {
+			return nil, invalidInput(errors.New("--ip or --fireplace is required"))
+		}
+		name = names[0]
+	}
+
+	fs, err := locateFireplaces(c, cfg, []string{name})
+	if err != nil {
+		return nil, err
+	}
+	return fs[0], nil
+}
+
+// fireplacesFromFlags returns the fireplace addressed by the --ip or --fireplace
+// flags. Without either, every fireplace in the configuration file is returned,
+// or every fireplace found on the network if none are configured.
+func fireplacesFromFlags(c *cli.Context) ([]*firecontrol.Fireplace, error) {
+	if c.IsSet("ip") || c.IsSet("fireplace") {
+		fp, err := fireplaceFromFlags(c)
+		if err != nil {
+			return nil, err
+		}
+		return []*firecontrol.Fireplace{fp}, nil
+	}
+
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	if names := cfg.FireplaceNames(); len(names) > 0 {
+		return locateFireplaces(c, cfg, names)
+	}
+
+	fs, err := firecontrol.SearchForFireplaces()
+	if err != nil {
+		slog.Error("Error searching for fireplaces", "error", err)
+		return nil, err
+	}
+	if len(fs) == 0 {
+		return nil, fmt.Errorf("no fireplaces found")
+	}
+	for _, f := range fs {
+		f.Configure(fireplaceOptions(c)...)
+	}
+	return fs, nil
+}
+
+// locateFireplaces finds the named fireplaces from the configuration file.
+func locateFireplaces(c *cli.Context, cfg *config.Config, names []string) ([]*firecontrol.Fireplace, error) {
+	fs, err := cfg.Locate(names, fireplaceOptions(c)...)
+	if errors.Is(err, config.ErrFireplaceNotFound) {
+		return nil, invalidInput(err)
+	}
+	return fs, err
+}
+
+// loadConfig loads the configuration file selected by --config.
+func loadConfig(c *cli.Context) (*config.Config, error) {
+	cfg, err := config.Load(c.String("config"))
+	if err != nil {
+		return nil, invalidInput(err)
+	}
+	return cfg, nil
+}
+
+// readConfig reads the configuration file selected by --config without
+// applying environment overrides, for commands that write it back.
+func readConfig(c *cli.Context) (*config.Config, error) {
+	cfg, err := config.Read(c.String("config"))
+	if err != nil {
+		return nil, invalidInput(err)
+	}
+	return cfg, nil
+}
+
+// fireplaceOptions returns the options for fireplaces: the default rate limit
+// and those set by global flags.
+func fireplaceOptions(c *cli.Context) []firecontrol.Option {
+	opts := []firecontrol.Option{firecontrol.WithRateLimit(firecontrol.DefaultCommandInterval)}
+	if c.Bool("dry-run") {
+		// Keep stdout parseable when a machine readable format is selected.
+		w := os.Stdout
+		if c.String("output") != outputText {
+			w = os.Stderr
+		}
+		opts = append(opts, firecontrol.WithDryRun(w))
 	}
+	return opts
 }
 
 func formatBoolean(b bool) string {
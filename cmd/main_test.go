@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/config"
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
+)
+
+const homeKitConfig = `
+fireplaces:
+  lounge:
+    serial: 107757
+    pin: 1
+    ip: 10.0.0.40
+  study:
+    serial: 424242
+    pin: 2
+  den:
+    serial: 3
+    pin: 3
+    ip: not-an-ip
+`
+
+func TestLocateHomeKitFireplaces(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+		found []string
+		code  int
+	}{
+		// study has no ip and nothing on this network answers with its serial.
+		{"missing from the search", []string{"lounge", "study"}, []string{"lounge"}, 0},
+		{"invalid ip", []string{"lounge", "den"}, nil, exitInvalidInput},
+		{"not configured", []string{"lounge", "attic"}, nil, exitInvalidInput},
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(homeKitConfig), 0o600))
+	cfg, err := config.Load(path)
+	require.NoError(t, err)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				found      []string
+				fireplaces []*firecontrol.Fireplace
+			)
+			app := &cli.App{
+				Writer:    io.Discard,
+				ErrWriter: io.Discard,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "dry-run"},
+					&cli.StringFlag{Name: "output", Value: outputText},
+				},
+				Action: func(c *cli.Context) (err error) {
+					found, fireplaces, err = locateHomeKitFireplaces(c, cfg, tt.names)
+					return err
+				},
+			}
+
+			err := app.Run([]string{"firecontrol"})
+			assert.Equal(t, tt.code, exitCode(err), "error: %v", err)
+			assert.Equal(t, tt.found, found)
+			assert.Len(t, fireplaces, len(tt.found))
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	outputText  = "text"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+	outputTable = "table"
+)
+
+// result is implemented by everything a command prints, so it can be rendered
+// in any of the output formats. Field names in the json and yaml tags are part
+// of the CLI's interface and must stay stable.
+type result interface {
+	// text writes the human readable form of the result.
+	text(w io.Writer)
+	// table returns the header and rows for the table form of the result.
+	table() ([]string, [][]string)
+}
+
+type statusResult struct {
+	IP                string `json:"ip" yaml:"ip"`
+	Power             bool   `json:"power" yaml:"power"`
+	FlameEffect       bool   `json:"flame_effect" yaml:"flame_effect"`
+	FanBoost          bool   `json:"fan_boost" yaml:"fan_boost"`
+	HasTimers         bool   `json:"has_timers" yaml:"has_timers"`
+	TargetTemperature int    `json:"target_temperature" yaml:"target_temperature"`
+	RoomTemperature   int    `json:"room_temperature" yaml:"room_temperature"`
+}
+
+func newStatusResult(fp *firecontrol.Fireplace) statusResult {
+	return statusResultFor(fp.Addr.IP.String(), fp.Status)
+}
+
+func statusResultFor(ip string, status *firecontrol.Status) statusResult {
+	return statusResult{
+		IP:                ip,
+		Power:             status.IsOn,
+		FlameEffect:       status.FlameEffectIsOn,
+		FanBoost:          status.FanBoostIsOn,
+		HasTimers:         status.HasTimers,
+		TargetTemperature: int(status.TargetTempertaure),
+		RoomTemperature:   int(status.CurrentTemperature),
+	}
+}
+
+func (r statusResult) text(w io.Writer) {
+	fmt.Fprintf(w, "Fireplace: %s\n\tFire Status: %s\n\tFlame Effect: %s\n\tFan Boost: %s\n\tDesired Temperature: %dºC\n\tRoom Temperature: %dºC\n",
+		r.IP,
+		formatBoolean(r.Power),
+		formatBoolean(r.FlameEffect),
+		formatBoolean(r.FanBoost),
+		r.TargetTemperature, r.RoomTemperature,
+	)
+}
+
+func (r statusResult) table() ([]string, [][]string) {
+	return []string{"IP", "POWER", "FLAME EFFECT", "FAN BOOST", "TARGET", "ROOM"}, [][]string{{
+		r.IP,
+		formatBoolean(r.Power),
+		formatBoolean(r.FlameEffect),
+		formatBoolean(r.FanBoost),
+		fmt.Sprintf("%dºC", r.TargetTemperature),
+		fmt.Sprintf("%dºC", r.RoomTemperature),
+	}}
+}
+
+type searchResult struct {
+	IP     string `json:"ip" yaml:"ip"`
+	Serial uint32 `json:"serial" yaml:"serial"`
+	PIN    uint16 `json:"pin" yaml:"pin"`
+}
+
+type searchResults []searchResult
+
+func newSearchResults(fs []*firecontrol.Fireplace) searchResults {
+	results := make(searchResults, 0, len(fs))
+	for _, f := range fs {
+		results = append(results, searchResult{IP: f.Addr.IP.String(), Serial: f.Serial, PIN: f.PIN})
+	}
+	return results
+}
+
+func (r searchResults) text(w io.Writer) {
+	if len(r) == 0 {
+		fmt.Fprintln(w, "No fireplaces found")
+		return
+	}
+	for _, f := range r {
+		fmt.Fprintf(w, "Found fireplace: %s\n\tSerial: %d\n\tPIN: %d\n", f.IP, f.Serial, f.PIN)
+	}
+}
+
+func (r searchResults) table() ([]string, [][]string) {
+	rows := make([][]string, 0, len(r))
+	for _, f := range r {
+		rows = append(rows, []string{f.IP, fmt.Sprint(f.Serial), fmt.Sprint(f.PIN)})
+	}
+	return []string{"IP", "SERIAL", "PIN"}, rows
+}
+
+// commandResult is the result of a command that changes the fireplace.
+type commandResult struct {
+	IP          string `json:"ip" yaml:"ip"`
+	Action      string `json:"action" yaml:"action"`
+	Temperature *int   `json:"temperature,omitempty" yaml:"temperature,omitempty"`
+	DryRun      bool   `json:"dry_run" yaml:"dry_run"`
+}
+
+func (r commandResult) text(w io.Writer) {
+	fmt.Fprintf(w, "%s: %s\n", r.IP, commandMessage(r.Action, r.Temperature, r.DryRun))
+}
+
+// commandMessage describes a completed command for the text output.
+func commandMessage(action string, temperature *int, dryRun bool) string {
+	msg := map[string]string{
+		"power-on":  "Fireplace powered on",
+		"power-off": "Fireplace powered off",
+		"set-temp":  "Temperature set",
+	}[action]
+	if temperature != nil {
+		msg = fmt.Sprintf("%s to %dºC", msg, *temperature)
+	}
+	if dryRun {
+		msg += " (dry run)"
+	}
+	return msg
+}
+
+func (r commandResult) table() ([]string, [][]string) {
+	temp := ""
+	if r.Temperature != nil {
+		temp = fmt.Sprintf("%dºC", *r.Temperature)
+	}
+	return []string{"IP", "ACTION", "TEMPERATURE", "DRY RUN"}, [][]string{{r.IP, r.Action, temp, fmt.Sprint(r.DryRun)}}
+}
+
+// printResult writes r to the app's writer, stdout by default, in the
+// format selected by --output.
+func printResult(c *cli.Context, r result) error {
+	return writeResult(c.App.Writer, c.String("output"), r)
+}
+
+func writeResult(w io.Writer, format string, r result) error {
+	switch format {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case outputYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		return enc.Encode(r)
+	case outputTable:
+		header, rows := r.table()
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		writeTableRow(tw, header)
+		for _, row := range rows {
+			writeTableRow(tw, row)
+		}
+		return tw.Flush()
+	default:
+		r.text(w)
+		return nil
+	}
+}
+
+func writeTableRow(w io.Writer, cells []string) {
+	for i, cell := range cells {
+		if i > 0 {
+			fmt.Fprint(w, "\t")
+		}
+		fmt.Fprint(w, cell)
+	}
+	fmt.Fprintln(w)
+}
+
+func validateOutputFormat(format string) error {
+	switch format {
+	case outputText, outputJSON, outputYAML, outputTable:
+		return nil
+	}
+	return invalidInput(fmt.Errorf("unknown output format %q, expected text, json, yaml or table", format))
+}
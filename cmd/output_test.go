@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// TestResultFieldNames pins the field names of the json and yaml output, which
+// scripts rely on.
+func TestResultFieldNames(t *testing.T) {
+	temp := 22
+	status := statusResult{IP: "10.0.0.40", Power: true, TargetTemperature: 22, RoomTemperature: 19}
+	statusFields := []string{"fan_boost", "flame_effect", "has_timers", "ip", "power", "room_temperature", "target_temperature"}
+
+	tests := []struct {
+		name   string
+		result result
+		fields []string
+	}{
+		{"status", status, statusFields},
+		{"search", searchResults{{IP: "10.0.0.40", Serial: 107757, PIN: 1790}}, []string{"ip", "pin", "serial"}},
+		{"command", commandResult{IP: "10.0.0.40", Action: "set-temp", Temperature: &temp}, []string{"action", "dry_run", "ip", "temperature"}},
+		{"batch", batchResults{{Fireplace: "lounge", IP: "10.0.0.40", Action: "status", Temperature: &temp, OK: false, Error: "timeout", Status: &status}},
+			[]string{"action", "dry_run", "error", "fireplace", "ip", "ok", "status", "temperature"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, format := range []string{outputJSON, outputYAML} {
+				var out bytes.Buffer
+				require.NoError(t, writeResult(&out, format, tt.result))
+
+				// Lists are checked by their first item.
+				var decoded interface{}
+				if format == outputJSON {
+					require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+				} else {
+					require.NoError(t, yaml.Unmarshal(out.Bytes(), &decoded))
+				}
+				if list, ok := decoded.([]interface{}); ok {
+					require.NotEmpty(t, list)
+					decoded = list[0]
+				}
+				fields := decoded.(map[string]interface{})
+				assert.Equal(t, tt.fields, keys(fields), format)
+
+				if nested, ok := fields["status"].(map[string]interface{}); ok {
+					assert.Equal(t, statusFields, keys(nested), format)
+				}
+			}
+		})
+	}
+}
+
+func keys(m map[string]interface{}) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestValidateOutputFormat(t *testing.T) {
+	a := assert.New(t)
+
+	for _, format := range []string{outputText, outputJSON, outputYAML, outputTable} {
+		a.NoError(validateOutputFormat(format))
+	}
+	err := validateOutputFormat("xml")
+	a.Equal(exitInvalidInput, exitCode(err))
+	a.EqualError(err, `unknown output format "xml", expected text, json, yaml or table`)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
+	"github.com/urfave/cli/v2"
+)
+
+var presetCommand = &cli.Command{
+	Name:  "preset",
+	Usage: "Manage and apply named comfort presets",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "list",
+			Usage: "List presets",
+			Action: func(c *cli.Context) error {
+				cfg, err := loadConfig(c)
+				if err != nil {
+					return err
+				}
+
+				results := make(presetResults, 0, len(cfg.Presets))
+				for _, name := range cfg.PresetNames() {
+					results = append(results, presetResult{Name: name, State: cfg.Presets[name]})
+				}
+				return printResult(c, results)
+			},
+		},
+		{
+			Name:      "create",
+			Usage:     "Create or replace a preset",
+			ArgsUsage: "<name>",
+			Flags:     desiredStateFlags,
+			Action: func(c *cli.Context) error {
+				name, err := presetName(c)
+				if err != nil {
+					return err
+				}
+
+				desired, err := desiredStateFromFlags(c)
+				if err != nil {
+					return err
+				}
+
+				cfg, err := readConfig(c)
+				if err != nil {
+					return err
+				}
+
+				cfg.SetPreset(name, desired)
+				if err := cfg.Save(c.String("config")); err != nil {
+					return err
+				}
+				return printResult(c, presetResults{{Name: name, State: desired}})
+			},
+		},
+		{
+			Name:      "delete",
+			Usage:     "Delete a preset",
+			ArgsUsage: "<name>",
+			Action: func(c *cli.Context) error {
+				name, err := presetName(c)
+				if err != nil {
+					return err
+				}
+
+				cfg, err := readConfig(c)
+				if err != nil {
+					return err
+				}
+
+				if err := cfg.DeletePreset(name); err != nil {
+					return invalidInput(err)
+				}
+				return cfg.Save(c.String("config"))
+			},
+		},
+		{
+			Name:      "apply",
+			Usage:     "Apply a preset to a fireplace",
+			ArgsUsage: "<name>",
+			Flags:     targetFlags(),
+			Action: func(c *cli.Context) error {
+				name, err := presetName(c)
+				if err != nil {
+					return err
+				}
+
+				cfg, err := loadConfig(c)
+				if err != nil {
+					return err
+				}
+
+				preset, err := cfg.Preset(name)
+				if err != nil {
+					return invalidInput(err)
+				}
+
+				fp, err := fireplaceFromFlags(c)
+				if err != nil {
+					return err
+				}
+
+				result, err := fp.Apply(preset)
+				if result != nil && result.After != nil {
+					if printErr := printResult(c, newApplyResult(fp, result, c.Bool("dry-run"))); printErr != nil {
+						return printErr
+					}
+				}
+				return err
+			},
+		},
+	},
+}
+
+func presetName(c *cli.Context) (string, error) {
+	if c.NArg() != 1 {
+		return "", invalidInput(fmt.Errorf("expected a preset name"))
+	}
+	return c.Args().First(), nil
+}
+
+type presetResult struct {
+	Name  string                   `json:"name" yaml:"name"`
+	State firecontrol.DesiredState `json:"state" yaml:"state"`
+}
+
+type presetResults []presetResult
+
+func (r presetResults) text(w io.Writer) {
+	if len(r) == 0 {
+		fmt.Fprintln(w, "No presets")
+		return
+	}
+	for _, p := range r {
+		fmt.Fprintf(w, "%s: %s\n", p.Name, describeState(p.State))
+	}
+}
+
+func (r presetResults) table() ([]string, [][]string) {
+	rows := make([][]string, 0, len(r))
+	for _, p := range r {
+		rows = append(rows, []string{
+			p.Name,
+			formatOptionalBool(p.State.Power),
+			formatOptionalTemperature(p.State.TargetTemperature),
+			formatOptionalBool(p.State.FlameEffect),
+			formatOptionalBool(p.State.FanBoost),
+		})
+	}
+	return []string{"NAME", "POWER", "TARGET", "FLAME EFFECT", "FAN BOOST"}, rows
+}
+
+// describeState summarises the settings a desired state changes.
+func describeState(s firecontrol.DesiredState) string {
+	var parts []string
+	if s.Power != nil {
+		parts = append(parts, "power "+formatOptionalBool(s.Power))
+	}
+	if s.TargetTemperature != nil {
+		parts = append(parts, "target "+formatOptionalTemperature(s.TargetTemperature))
+	}
+	if s.FlameEffect != nil {
+		parts = append(parts, "flame effect "+formatOptionalBool(s.FlameEffect))
+	}
+	if s.FanBoost != nil {
+		parts = append(parts, "fan boost "+formatOptionalBool(s.FanBoost))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatOptionalBool(b *bool) string {
+	if b == nil {
+		return "-"
+	}
+	return formatBoolean(*b)
+}
+
+func formatOptionalTemperature(t *int) string {
+	if t == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%dºC", *t)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+)
+
+var rawCommand = &cli.Command{
+	Name:  "raw",
+	Usage: "Send a raw command to a fireplace and print every decoded reply",
+	Description: `Builds a correctly framed packet for the given command code and data, sends it
+to the fireplace and prints every reply received within the window.
+
+Only command codes documented for the remote are allowed unless --unsafe is set.
+Use --interactive to start a console where each line is "<cmd> [data]".`,
+	Flags: append(targetFlags(),
+		&cli.StringFlag{
+			Name:  "cmd",
+			Usage: "Command code to send, e.g. 0x31",
+		},
+		&cli.StringFlag{
+			Name:  "data",
+			Usage: "Hex encoded command data, e.g. 16 for 22ºC",
+		},
+		&cli.DurationFlag{
+			Name:  "window",
+			Usage: "How long to wait for replies",
+			Value: 3 * time.Second,
+		},
+		&cli.BoolFlag{
+			Name:  "unsafe",
+			Usage: "Allow command codes that are not known remote commands",
+		},
+		&cli.BoolFlag{
+			Name:    "interactive",
+			Aliases: []string{"i"},
+			Usage:   "Start an interactive console",
+		},
+	),
+	Action: func(c *cli.Context) error {
+		fp, err := fireplaceFromFlags(c)
+		if err != nil {
+			return err
+		}
+		console := &rawConsole{
+			fireplace: fp,
+			window:    c.Duration("window"),
+			unsafe:    c.Bool("unsafe"),
+			out:       os.Stdout,
+		}
+
+		if c.Bool("interactive") {
+			return console.repl(os.Stdin)
+		}
+
+		if !c.IsSet("cmd") {
+			return invalidInput(fmt.Errorf("--cmd is required unless --interactive is set"))
+		}
+		result, err := console.send(c.String("cmd"), c.String("data"))
+		if err != nil {
+			return err
+		}
+		return printResult(c, result)
+	},
+}
+
+type rawConsole struct {
+	fireplace *firecontrol.Fireplace
+	window    time.Duration
+	unsafe    bool
+	out       io.Writer
+	history   []string
+}
+
+func (r *rawConsole) send(cmdArg, dataArg string) (*rawResult, error) {
+	code, err := parseCommandCode(cmdArg)
+	if err != nil {
+		return nil, err
+	}
+
+	if !code.IsRemoteCommand() && !r.unsafe {
+		return nil, invalidInput(fmt.Errorf("%s is not a known remote command, use --unsafe to send it anyway", code))
+	}
+
+	data, err := hex.DecodeString(strings.TrimPrefix(dataArg, "0x"))
+	if err != nil {
+		return nil, invalidInput(fmt.Errorf("invalid data %q: %w", dataArg, err))
+	}
+
+	packet, err := firecontrol.MarshalCommandPacket(code, data)
+	if err != nil {
+		return nil, err
+	}
+
+	replies, err := r.fireplace.SendRaw(code, data, r.window)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &rawResult{
+		IP:      r.fireplace.Addr.IP.String(),
+		Sent:    hex.EncodeToString(packet),
+		Replies: make([]rawReply, 0, len(replies)),
+		window:  r.window,
+	}
+	for _, reply := range replies {
+		decoded := ""
+		if payload, err := reply.Decode(); err == nil {
+			decoded = fmt.Sprintf("%+v", payload)
+		}
+		result.Replies = append(result.Replies, rawReply{
+			Command: reply.CommandID.String(),
+			Code:    uint8(reply.CommandID),
+			Data:    hex.EncodeToString(reply.Data[:min(reply.DataSize, uint8(len(reply.Data)))]),
+			Decoded: decoded,
+			frame:   reply,
+		})
+	}
+	return result, nil
+}
+
+func (r *rawConsole) repl(in *os.File) error {
+	fd := int(in.Fd())
+
+	var readLine func() (string, error)
+	if term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		defer term.Restore(fd, state)
+
+		t := term.NewTerminal(in, "firecontrol> ")
+		r.out = t
+		readLine = t.ReadLine
+	} else {
+		scanner := bufio.NewScanner(in)
+		readLine = func() (string, error) {
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return "", err
+				}
+				return "", io.EOF
+			}
+			return scanner.Text(), nil
+		}
+	}
+
+	fmt.Fprintln(r.out, `Enter "<cmd> [data]", "history", "!<n>" to repeat an entry, or "exit"`)
+
+	for {
+		line, err := readLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "!") {
+			n, err := strconv.Atoi(line[1:])
+			if err != nil || n < 1 || n > len(r.history) {
+				fmt.Fprintf(r.out, "No history entry %q\n", line[1:])
+				continue
+			}
+			line = r.history[n-1]
+			fmt.Fprintln(r.out, line)
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "exit", "quit":
+			return nil
+		case "history":
+			for i, entry := range r.history {
+				fmt.Fprintf(r.out, "%4d  %s\n", i+1, entry)
+			}
+			continue
+		}
+
+		r.history = append(r.history, line)
+
+		data := ""
+		if len(fields) > 1 {
+			data = strings.Join(fields[1:], "")
+		}
+		result, err := r.send(fields[0], data)
+		if err != nil {
+			fmt.Fprintf(r.out, "Error: %v\n", err)
+			continue
+		}
+		result.text(r.out)
+	}
+}
+
+func parseCommandCode(s string) (firecontrol.CommandCode, error) {
+	code, err := strconv.ParseUint(s, 0, 8)
+	if err != nil {
+		return 0, invalidInput(fmt.Errorf("invalid command code %q", s))
+	}
+	return firecontrol.CommandCode(code), nil
+}
+
+type rawReply struct {
+	Command string `json:"command" yaml:"command"`
+	Code    uint8  `json:"code" yaml:"code"`
+	Data    string `json:"data" yaml:"data"`
+	Decoded string `json:"decoded,omitempty" yaml:"decoded,omitempty"`
+
+	frame *firecontrol.Command
+}
+
+type rawResult struct {
+	IP      string     `json:"ip" yaml:"ip"`
+	Sent    string     `json:"sent" yaml:"sent"`
+	Replies []rawReply `json:"replies" yaml:"replies"`
+
+	window time.Duration
+}
+
+func (r *rawResult) text(w io.Writer) {
+	fmt.Fprintf(w, "-> %s\n", r.Sent)
+	for _, reply := range r.Replies {
+		fmt.Fprintf(w, "<- %s\n", reply.frame)
+		if reply.Decoded != "" {
+			fmt.Fprintf(w, "   %s\n", reply.Decoded)
+		}
+	}
+	if len(r.Replies) == 0 {
+		fmt.Fprintf(w, "No replies within %s\n", r.window)
+	}
+}
+
+func (r *rawResult) table() ([]string, [][]string) {
+	rows := make([][]string, 0, len(r.Replies))
+	for _, reply := range r.Replies {
+		rows = append(rows, []string{reply.Command, reply.Data, reply.Decoded})
+	}
+	return []string{"COMMAND", "DATA", "DECODED"}, rows
+}
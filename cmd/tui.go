@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+)
+
+const (
+	ansiAltScreenOn  = "\033[?1049h"
+	ansiAltScreenOff = "\033[?1049l"
+	ansiHideCursor   = "\033[?25l"
+	ansiShowCursor   = "\033[?25h"
+	ansiReverse      = "\033[7m"
+)
+
+var tuiCommand = &cli.Command{
+	Name:  "tui",
+	Usage: "Control fireplaces from an interactive terminal interface",
+	Description: `Lists the fireplace given by --ip, or every fireplace found on the network, with
+its live status.
+
+Keys: ↑/↓ select a fireplace, p toggle power, f toggle flame effect, b toggle fan
+boost, ←/→ or -/+ change the target temperature, r refresh, q quit.`,
+	Flags: append(targetFlags(),
+		&cli.DurationFlag{
+			Name:  "interval",
+			Usage: "How often to refresh the status",
+			Value: 5 * time.Second,
+		},
+	),
+	Action: func(c *cli.Context) error {
+		fd := int(os.Stdin.Fd())
+		if !term.IsTerminal(fd) {
+			return invalidInput(fmt.Errorf("tui must be run in a terminal"))
+		}
+
+		fireplaces, err := fireplacesFromFlags(c)
+		if err != nil {
+			return err
+		}
+
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		defer term.Restore(fd, state)
+
+		fmt.Fprint(os.Stdout, ansiAltScreenOn+ansiHideCursor)
+		defer fmt.Fprint(os.Stdout, ansiShowCursor+ansiAltScreenOff)
+
+		t := newTUI(fireplaces, c.Bool("dry-run"), os.Stdout)
+		return t.run(os.Stdin, c.Duration("interval"))
+	},
+}
+
+type tuiKey int
+
+const (
+	keyUnknown tuiKey = iota
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyQuit
+	keyPower
+	keyFlameEffect
+	keyFanBoost
+	keyRefresh
+)
+
+// keyPollInterval is how often the key reader checks whether the interface has
+// exited while it waits for a key press.
+const keyPollInterval = 100 * time.Millisecond
+
+// tuiJobBacklog is how many jobs can wait for a fireplace that has not
+// answered before further key presses for it are turned away.
+const tuiJobBacklog = 8
+
+// tuiJob is work for a fireplace's worker, returning what to show once done.
+type tuiJob func(fp *firecontrol.Fireplace) tuiResult
+
+// tuiResult is the outcome of a job, posted back to the interface.
+type tuiResult struct {
+	index   int
+	command bool
+
+	// status is the fireplace's status if it was refreshed, and err why it
+	// could not be.
+	status *firecontrol.Status
+	err    error
+
+	// message replaces the status line if set.
+	message string
+}
+
+type tui struct {
+	fireplaces []*firecontrol.Fireplace
+	dryRun     bool
+	selected   int
+	message    string
+	out        io.Writer
+
+	// The interface only reads the statuses posted back by the workers, never
+	// the fireplaces, which belong to their workers.
+	statuses []*firecontrol.Status
+	errors   map[int]error
+
+	jobs    []chan tuiJob
+	pending []int  // jobs queued for each fireplace
+	sending []bool // whether a command is queued for each fireplace
+	results chan tuiResult
+}
+
+func newTUI(fireplaces []*firecontrol.Fireplace, dryRun bool, out io.Writer) *tui {
+	t := &tui{
+		fireplaces: fireplaces,
+		dryRun:     dryRun,
+		out:        out,
+		statuses:   make([]*firecontrol.Status, len(fireplaces)),
+		errors:     make(map[int]error),
+		jobs:       make([]chan tuiJob, len(fireplaces)),
+		pending:    make([]int, len(fireplaces)),
+		sending:    make([]bool, len(fireplaces)),
+		results:    make(chan tuiResult),
+	}
+	for i := range t.jobs {
+		t.jobs[i] = make(chan tuiJob, tuiJobBacklog)
+	}
+	return t
+}
+
+func (t *tui) run(in io.Reader, interval time.Duration) error {
+	done := make(chan struct{})
+	keys := make(chan tuiKey)
+	go readKeys(in, keys, done)
+	defer func() {
+		close(done)
+		// Give the reader time to stop so it does not take a key press meant
+		// for the shell once the terminal is restored.
+		stopped := time.After(2 * keyPollInterval)
+		for {
+			select {
+			case _, ok := <-keys:
+				if !ok {
+					return
+				}
+			case <-stopped:
+				return
+			}
+		}
+	}()
+
+	t.start(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	t.refreshAll()
+	for {
+		t.draw()
+
+		select {
+		case <-ticker.C:
+			t.refreshAll()
+
+		case result := <-t.results:
+			t.apply(result)
+
+		case key, ok := <-keys:
+			if !ok || key == keyQuit {
+				return nil
+			}
+			t.handle(key)
+		}
+	}
+}
+
+// start runs a worker for each fireplace until done is closed. Each fireplace
+// has its own so its commands never overlap, and one that is unreachable only
+// holds up itself.
+func (t *tui) start(done <-chan struct{}) {
+	for i, fp := range t.fireplaces {
+		// Dry-run output is shown on the status line rather than written over
+		// the screen.
+		var dryRun bytes.Buffer
+		if t.dryRun {
+			fp.Configure(firecontrol.WithDryRun(&dryRun))
+		}
+
+		go func() {
+			for {
+				var job tuiJob
+				select {
+				case <-done:
+					return
+				case job = <-t.jobs[i]:
+				}
+
+				result := job(fp)
+				result.index = i
+				if output := strings.TrimSpace(dryRun.String()); output != "" {
+					result.message = strings.ReplaceAll(output, "\n", " · ")
+				}
+				dryRun.Reset()
+
+				select {
+				case <-done:
+					return
+				case t.results <- result:
+				}
+			}
+		}()
+	}
+}
+
+// queue hands job to the worker for fireplace i, reporting false if too much
+// is already waiting for it.
+func (t *tui) queue(i int, job tuiJob) bool {
+	select {
+	case t.jobs[i] <- job:
+		t.pending[i]++
+		return true
+	default:
+		return false
+	}
+}
+
+// apply shows the outcome of a job.
+func (t *tui) apply(result tuiResult) {
+	i := result.index
+	t.pending[i]--
+	if result.command {
+		t.sending[i] = false
+	}
+
+	switch {
+	case result.err != nil:
+		t.errors[i] = result.err
+	case result.status != nil:
+		t.statuses[i] = result.status
+		delete(t.errors, i)
+	}
+
+	if result.message != "" {
+		t.message = result.message
+	}
+}
+
+func (t *tui) handle(key tuiKey) {
+	i := t.selected
+	ip := t.fireplaces[i].Addr.IP
+
+	switch key {
+	case keyUp:
+		t.selected = max(t.selected-1, 0)
+		return
+	case keyDown:
+		t.selected = min(t.selected+1, len(t.fireplaces)-1)
+		return
+	case keyRefresh:
+		if !t.queue(i, func(fp *firecontrol.Fireplace) tuiResult {
+			result := refreshResult(fp)
+			if result.err == nil {
+				result.message = fmt.Sprintf("Refreshed %s", ip)
+			}
+			return result
+		}) {
+			t.message = fmt.Sprintf("Busy, %s has not answered yet", ip)
+		}
+		return
+	}
+
+	status := t.statuses[i]
+	if status == nil {
+		t.message = "Status unknown, press r to refresh"
+		return
+	}
+
+	var (
+		action  string
+		command func(fp *firecontrol.Fireplace) error
+	)
+	switch key {
+	case keyPower:
+		if status.IsOn {
+			action, command = "Powered off", (*firecontrol.Fireplace).PowerOff
+		} else {
+			action, command = "Powered on", (*firecontrol.Fireplace).PowerOn
+		}
+	case keyFlameEffect:
+		action = "Toggled flame effect"
+		command = func(fp *firecontrol.Fireplace) error { return fp.SetFlameEffect(!status.FlameEffectIsOn) }
+	case keyFanBoost:
+		action = "Toggled fan boost"
+		command = func(fp *firecontrol.Fireplace) error { return fp.SetFanBoost(!status.FanBoostIsOn) }
+	case keyLeft, keyRight:
+		temp := int(status.TargetTempertaure) - 1
+		if key == keyRight {
+			temp += 2
+		}
+		action = fmt.Sprintf("Set target temperature to %dºC", temp)
+		command = func(fp *firecontrol.Fireplace) error { return fp.SetTemperature(temp) }
+	default:
+		t.message = ""
+		return
+	}
+
+	// Commands are worked out from the status on screen, so wait for one to
+	// show before sending another.
+	if t.sending[i] {
+		t.message = fmt.Sprintf("Busy, still sending to %s", ip)
+		return
+	}
+
+	queued := t.queue(i, func(fp *firecontrol.Fireplace) tuiResult {
+		if err := command(fp); err != nil {
+			return tuiResult{command: true, message: fmt.Sprintf("Error: %v", err)}
+		}
+		result := refreshResult(fp)
+		result.command = true
+		result.message = fmt.Sprintf("%s on %s", action, ip)
+		return result
+	})
+	if !queued {
+		t.message = fmt.Sprintf("Busy, %s has not answered yet", ip)
+		return
+	}
+	t.sending[i] = true
+	t.message = "Sending..."
+}
+
+// refreshAll refreshes every fireplace that is not still busy.
+func (t *tui) refreshAll() {
+	for i := range t.fireplaces {
+		if t.pending[i] == 0 {
+			t.queue(i, func(fp *firecontrol.Fireplace) tuiResult {
+				return refreshResult(fp)
+			})
+		}
+	}
+}
+
+// refreshResult refreshes fp's status, run by its worker.
+func refreshResult(fp *firecontrol.Fireplace) tuiResult {
+	if err := fp.Refresh(); err != nil {
+		return tuiResult{err: err}
+	}
+	return tuiResult{status: fp.Status}
+}
+
+func (t *tui) draw() {
+	var b strings.Builder
+	b.WriteString(ansiClearScreen)
+	b.WriteString("firecontrol · ↑/↓ select · p power · f flame effect · b fan boost · ←/→ target · r refresh · q quit\r\n\r\n")
+
+	for i, fp := range t.fireplaces {
+		status := t.statuses[i]
+		line := fmt.Sprintf("%-15s  ", fp.Addr.IP)
+		switch {
+		case t.errors[i] != nil:
+			line += fmt.Sprintf("unreachable: %v", t.errors[i])
+		case status == nil:
+			line += "status unknown"
+		default:
+			line += fmt.Sprintf("Power: %-3s  Flame effect: %-3s  Fan boost: %-3s  Target: %2dºC  Room: %2dºC",
+				formatBoolean(status.IsOn),
+				formatBoolean(status.FlameEffectIsOn),
+				formatBoolean(status.FanBoostIsOn),
+				status.TargetTempertaure,
+				status.CurrentTemperature,
+			)
+		}
+
+		if i == t.selected {
+			line = ansiReverse + "> " + line + ansiReset
+		} else {
+			line = "  " + line
+		}
+		b.WriteString(line + "\r\n")
+	}
+
+	b.WriteString("\r\n" + t.message + "\r\n")
+	io.WriteString(t.out, b.String())
+}
+
+// readKeys decodes key presses from in until it is closed or done is. A read
+// from a terminal cannot be interrupted, so when in is one the reader only
+// reads once a key has been pressed, checking for done in between.
+func readKeys(in io.Reader, keys chan<- tuiKey, done <-chan struct{}) {
+	defer close(keys)
+
+	f, isFile := in.(*os.File)
+	buf := make([]byte, 16)
+	for {
+		if isFile {
+			ready, err := waitForInput(f, keyPollInterval)
+			if err != nil {
+				return
+			}
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if !ready {
+				continue
+			}
+		}
+
+		n, err := in.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, key := range parseKeys(buf[:n]) {
+			select {
+			case <-done:
+				return
+			case keys <- key:
+			}
+		}
+	}
+}
+
+func parseKeys(b []byte) []tuiKey {
+	var keys []tuiKey
+	for len(b) > 0 {
+		if len(b) >= 3 && b[0] == 0x1b && b[1] == '[' {
+			switch b[2] {
+			case 'A':
+				keys = append(keys, keyUp)
+			case 'B':
+				keys = append(keys, keyDown)
+			case 'C':
+				keys = append(keys, keyRight)
+			case 'D':
+				keys = append(keys, keyLeft)
+			}
+			b = b[3:]
+			continue
+		}
+
+		switch b[0] {
+		case 'q', 0x03, 0x04: // q, Ctrl-C, Ctrl-D
+			keys = append(keys, keyQuit)
+		case 'k':
+			keys = append(keys, keyUp)
+		case 'j':
+			keys = append(keys, keyDown)
+		case '-', 'h':
+			keys = append(keys, keyLeft)
+		case '+', '=', 'l':
+			keys = append(keys, keyRight)
+		case 'p':
+			keys = append(keys, keyPower)
+		case 'f':
+			keys = append(keys, keyFlameEffect)
+		case 'b':
+			keys = append(keys, keyFanBoost)
+		case 'r':
+			keys = append(keys, keyRefresh)
+		}
+		b = b[1:]
+	}
+	return keys
+}
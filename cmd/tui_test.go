@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
+)
+
+func TestReadKeysStops(t *testing.T) {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer r.Close()
+	defer w.Close()
+
+	keys := make(chan tuiKey)
+	done := make(chan struct{})
+	go readKeys(r, keys, done)
+
+	_, err = w.Write([]byte("p\x1b[B"))
+	require.NoError(t, err)
+	assert.Equal(t, keyPower, <-keys)
+	assert.Equal(t, keyDown, <-keys)
+
+	// Nothing more is typed, so the reader is waiting for a key press.
+	close(done)
+	select {
+	case _, ok := <-keys:
+		assert.False(t, ok, "reader kept running")
+	case <-time.After(time.Second):
+		t.Fatal("reader did not stop")
+	}
+}
+
+func TestTUIQueuesCommands(t *testing.T) {
+	a := assert.New(t)
+
+	// No workers are started, so nothing is sent.
+	ui := newTUI([]*firecontrol.Fireplace{firecontrol.NewFireplace(net.IPv4(127, 0, 0, 2))}, false, &bytes.Buffer{})
+
+	ui.handle(keyPower)
+	a.Equal("Status unknown, press r to refresh", ui.message)
+	a.Len(ui.jobs[0], 0)
+
+	ui.statuses[0] = &firecontrol.Status{IsOn: true, TargetTempertaure: 22}
+	ui.handle(keyPower)
+	a.Equal("Sending...", ui.message)
+	a.Len(ui.jobs[0], 1)
+
+	ui.handle(keyRight)
+	a.Equal("Busy, still sending to 127.0.0.2", ui.message)
+	a.Len(ui.jobs[0], 1)
+
+	// A refresh can still queue behind the command.
+	ui.handle(keyRefresh)
+	a.Len(ui.jobs[0], 2)
+	a.Equal(2, ui.pending[0])
+
+	ui.apply(tuiResult{index: 0, command: true, status: &firecontrol.Status{TargetTempertaure: 22}, message: "Powered off on 127.0.0.2"})
+	a.Equal("Powered off on 127.0.0.2", ui.message)
+	a.False(ui.sending[0])
+	a.False(ui.statuses[0].IsOn)
+}
+
+func TestTUIDryRunOnStatusLine(t *testing.T) {
+	var out bytes.Buffer
+	ui := newTUI([]*firecontrol.Fireplace{firecontrol.NewFireplace(net.IPv4(127, 0, 0, 2))}, true, &out)
+
+	done := make(chan struct{})
+	defer close(done)
+	ui.start(done)
+
+	require.True(t, ui.queue(0, func(fp *firecontrol.Fireplace) tuiResult {
+		if err := fp.PowerOn(); err != nil {
+			return tuiResult{message: err.Error()}
+		}
+		return tuiResult{message: "Powered on"}
+	}))
+
+	select {
+	case result := <-ui.results:
+		ui.apply(result)
+	case <-time.After(time.Second):
+		t.Fatal("no result")
+	}
+
+	assert.Contains(t, ui.message, "dry-run: PowerOn(0x39)")
+	assert.Zero(t, out.Len(), "dry-run output written over the screen")
+}
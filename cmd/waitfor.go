@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
+	"github.com/urfave/cli/v2"
+)
+
+var errTimeout = errors.New("timed out waiting for condition")
+
+var waitForCommand = &cli.Command{
+	Name:  "wait-for",
+	Usage: "Wait until the fireplace matches a condition",
+	Description: `Polls the fireplace until every given condition holds, then exits zero.
+Exits with code 5 if the conditions do not hold before the timeout.
+
+Temperature conditions take a comparison and a value, e.g. '>=21', '<18' or '22'.
+Power, flame effect and fan boost conditions take on or off.`,
+	Flags: append(targetFlags(),
+		&cli.StringFlag{
+			Name:  "room-temp",
+			Usage: "Room temperature condition, e.g. '>=21'",
+		},
+		&cli.StringFlag{
+			Name:  "target-temp",
+			Usage: "Target temperature condition, e.g. '22'",
+		},
+		&cli.StringFlag{
+			Name:  "power",
+			Usage: "Power condition, on or off",
+		},
+		&cli.StringFlag{
+			Name:  "flame-effect",
+			Usage: "Flame effect condition, on or off",
+		},
+		&cli.StringFlag{
+			Name:  "fan-boost",
+			Usage: "Fan boost condition, on or off",
+		},
+		&cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "How long to wait before giving up",
+			Value: 30 * time.Minute,
+		},
+		&cli.DurationFlag{
+			Name:  "interval",
+			Usage: "How often to poll the fireplace",
+			Value: 10 * time.Second,
+		},
+	),
+	Action: func(c *cli.Context) error {
+		conditions, err := conditionsFromFlags(c)
+		if err != nil {
+			return err
+		}
+
+		fp, err := fireplaceFromFlags(c)
+		if err != nil {
+			return err
+		}
+
+		deadline := time.After(c.Duration("timeout"))
+		ticker := time.NewTicker(c.Duration("interval"))
+		defer ticker.Stop()
+
+		for {
+			err := fp.Refresh()
+			if err != nil {
+				slog.Warn("Failed to refresh fireplace", "error", err)
+			} else if pending := unmetConditions(conditions, fp.Status); len(pending) == 0 {
+				return printResult(c, newStatusResult(fp))
+			} else {
+				slog.Debug("Waiting for fireplace", "pending", strings.Join(pending, ", "))
+			}
+
+			select {
+			case <-deadline:
+				return errTimeout
+			case <-c.Context.Done():
+				return c.Context.Err()
+			case <-ticker.C:
+			}
+		}
+	},
+}
+
+// condition is a single check against a fireplace status.
+type condition struct {
+	description string
+	holds       func(*firecontrol.Status) bool
+}
+
+func conditionsFromFlags(c *cli.Context) ([]condition, error) {
+	var conditions []condition
+
+	temperatures := []struct {
+		flag  string
+		value func(*firecontrol.Status) uint8
+	}{
+		{"room-temp", func(s *firecontrol.Status) uint8 { return s.CurrentTemperature }},
+		{"target-temp", func(s *firecontrol.Status) uint8 { return s.TargetTempertaure }},
+	}
+	for _, t := range temperatures {
+		if !c.IsSet(t.flag) {
+			continue
+		}
+		compare, err := parseComparison(c.String(t.flag))
+		if err != nil {
+			return nil, invalidInput(fmt.Errorf("--%s: %w", t.flag, err))
+		}
+		value := t.value
+		conditions = append(conditions, condition{
+			description: fmt.Sprintf("%s %s", t.flag, c.String(t.flag)),
+			holds:       func(s *firecontrol.Status) bool { return compare(int(value(s))) },
+		})
+	}
+
+	switches := []struct {
+		flag  string
+		value func(*firecontrol.Status) bool
+	}{
+		{"power", func(s *firecontrol.Status) bool { return s.IsOn }},
+		{"flame-effect", func(s *firecontrol.Status) bool { return s.FlameEffectIsOn }},
+		{"fan-boost", func(s *firecontrol.Status) bool { return s.FanBoostIsOn }},
+	}
+	for _, sw := range switches {
+		if !c.IsSet(sw.flag) {
+			continue
+		}
+		want, err := parseOnOff(c.String(sw.flag))
+		if err != nil {
+			return nil, invalidInput(fmt.Errorf("--%s: %w", sw.flag, err))
+		}
+		value := sw.value
+		conditions = append(conditions, condition{
+			description: fmt.Sprintf("%s %s", sw.flag, c.String(sw.flag)),
+			holds:       func(s *firecontrol.Status) bool { return value(s) == want },
+		})
+	}
+
+	if len(conditions) == 0 {
+		return nil, invalidInput(errors.New("at least one condition is required"))
+	}
+	return conditions, nil
+}
+
+// unmetConditions returns the descriptions of the conditions that do not hold.
+func unmetConditions(conditions []condition, status *firecontrol.Status) []string {
+	var pending []string
+	for _, cond := range conditions {
+		if !cond.holds(status) {
+			pending = append(pending, cond.description)
+		}
+	}
+	return pending
+}
+
+// parseComparison parses expressions like ">=21" into a comparison against
+// that value. A bare number means equal to.
+func parseComparison(expr string) (func(int) bool, error) {
+	expr = strings.TrimSpace(expr)
+
+	operators := []struct {
+		op      string
+		compare func(a, b int) bool
+	}{
+		// Two character operators must be matched first.
+		{">=", func(a, b int) bool { return a >= b }},
+		{"<=", func(a, b int) bool { return a <= b }},
+		{"==", func(a, b int) bool { return a == b }},
+		{"!=", func(a, b int) bool { return a != b }},
+		{">", func(a, b int) bool { return a > b }},
+		{"<", func(a, b int) bool { return a < b }},
+		{"=", func(a, b int) bool { return a == b }},
+		{"", func(a, b int) bool { return a == b }},
+	}
+
+	for _, o := range operators {
+		if !strings.HasPrefix(expr, o.op) {
+			continue
+		}
+		want, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(expr, o.op)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition %q", expr)
+		}
+		compare := o.compare
+		return func(got int) bool { return compare(got, want) }, nil
+	}
+	return nil, fmt.Errorf("invalid condition %q", expr)
+}
+
+func parseOnOff(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "on", "true", "yes":
+		return true, nil
+	case "off", "false", "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected on or off, got %q", s)
+}
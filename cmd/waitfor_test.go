@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseComparison(t *testing.T) {
+	tests := []struct {
+		expr  string
+		match []int
+		miss  []int
+	}{
+		{">=21", []int{21, 25}, []int{20}},
+		{"<=18", []int{18, 3}, []int{19}},
+		{"==20", []int{20}, []int{19, 21}},
+		{"!=20", []int{19, 21}, []int{20}},
+		{">21", []int{22}, []int{21}},
+		{"<21", []int{20}, []int{21}},
+		{"=20", []int{20}, []int{21}},
+		{"20", []int{20}, []int{21}},
+		{" >= 21 ", []int{21}, []int{20}},
+		{">-1", []int{0}, []int{-1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			compare, err := parseComparison(tt.expr)
+			if !assert.NoError(t, err) {
+				return
+			}
+			for _, v := range tt.match {
+				assert.True(t, compare(v), "%d %s", v, tt.expr)
+			}
+			for _, v := range tt.miss {
+				assert.False(t, compare(v), "%d %s", v, tt.expr)
+			}
+		})
+	}
+}
+
+func TestParseComparisonInvalid(t *testing.T) {
+	for _, expr := range []string{"", ">", "=>21", ">=warm", "21.5", "~21"} {
+		t.Run(expr, func(t *testing.T) {
+			_, err := parseComparison(expr)
+			assert.EqualError(t, err, `invalid condition "`+expr+`"`)
+		})
+	}
+}
+
+func TestParseOnOff(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    bool
+		wantErr bool
+	}{
+		{"on", true, false},
+		{"ON", true, false},
+		{"true", true, false},
+		{"yes", true, false},
+		{"off", false, false},
+		{"false", false, false},
+		{"No", false, false},
+		{"1", false, true},
+		{"", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseOnOff(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+)
+
+const (
+	ansiClearScreen = "\033[H\033[2J"
+	ansiHighlight   = "\033[1;33m"
+	ansiReset       = "\033[0m"
+
+	// Number of room temperature readings the trend is measured over.
+	trendSamples = 10
+)
+
+var watchCommand = &cli.Command{
+	Name:  "watch",
+	Usage: "Watch the status of one or all fireplaces",
+	Description: `Polls the fireplace on an interval and redraws its status, highlighting fields
+that changed since the last poll and showing whether the room is warming or cooling.
+Without --ip every fireplace found on the network is watched.
+
+With --output json one JSON object is printed per change, for piping into other tools.
+Other output formats are not supported.`,
+	Flags: append(targetFlags(),
+		&cli.DurationFlag{
+			Name:  "interval",
+			Usage: "How often to poll the fireplace",
+			Value: 5 * time.Second,
+		},
+	),
+	Action: func(c *cli.Context) error {
+		format := c.String("output")
+		if format != outputText && format != outputJSON {
+			return invalidInput(fmt.Errorf("watch does not support --output %s, use text or json", format))
+		}
+
+		fireplaces, err := fireplacesFromFlags(c)
+		if err != nil {
+			return err
+		}
+
+		w := &watcher{
+			fireplaces: fireplaces,
+			format:     format,
+			out:        os.Stdout,
+			redraw:     term.IsTerminal(int(os.Stdout.Fd())),
+			previous:   make(map[string]statusResult),
+			history:    make(map[string][]int),
+		}
+
+		ticker := time.NewTicker(c.Duration("interval"))
+		defer ticker.Stop()
+
+		for {
+			w.poll()
+			select {
+			case <-c.Context.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	},
+}
+
+// watchEvent is a single observation of a fireplace.
+type watchEvent struct {
+	Time time.Time `json:"time"`
+	statusResult
+	Trend   string   `json:"trend"`
+	Changed []string `json:"changed"`
+	Error   string   `json:"error,omitempty"`
+
+	first bool
+}
+
+type watcher struct {
+	fireplaces []*firecontrol.Fireplace
+	format     string
+	out        io.Writer
+	redraw     bool
+
+	previous map[string]statusResult
+	history  map[string][]int
+}
+
+// reportable reports whether the event should be printed when only changes are
+// being printed.
+func (e watchEvent) reportable() bool {
+	return e.first || len(e.Changed) > 0 || e.Error != ""
+}
+
+func (w *watcher) poll() {
+	events := make([]watchEvent, 0, len(w.fireplaces))
+	for _, fp := range w.fireplaces {
+		events = append(events, w.observe(fp))
+	}
+
+	if w.format == outputJSON {
+		enc := json.NewEncoder(w.out)
+		for _, event := range events {
+			if event.reportable() {
+				enc.Encode(event)
+			}
+		}
+		return
+	}
+
+	if w.redraw {
+		fmt.Fprint(w.out, ansiClearScreen)
+		w.table(events)
+		return
+	}
+
+	// When not attached to a terminal only print the fireplaces that changed.
+	changed := events[:0]
+	for _, event := range events {
+		if event.reportable() {
+			changed = append(changed, event)
+		}
+	}
+	if len(changed) > 0 {
+		w.table(changed)
+	}
+}
+
+func (w *watcher) observe(fp *firecontrol.Fireplace) watchEvent {
+	ip := fp.Addr.IP.String()
+	event := watchEvent{Time: time.Now()}
+
+	if err := fp.Refresh(); err != nil {
+		event.IP = ip
+		event.Error = err.Error()
+		event.Changed = []string{}
+		if previous, ok := w.previous[ip]; ok {
+			event.statusResult = previous
+		}
+		return event
+	}
+
+	current := newStatusResult(fp)
+	event.statusResult = current
+
+	previous, seen := w.previous[ip]
+	event.Changed = []string{}
+	if seen {
+		event.Changed = changedFields(previous, current)
+	}
+	event.first = !seen
+	w.previous[ip] = current
+
+	history := append(w.history[ip], current.RoomTemperature)
+	if len(history) > trendSamples {
+		history = history[len(history)-trendSamples:]
+	}
+	w.history[ip] = history
+	event.Trend = trend(history)
+
+	return event
+}
+
+// watchCell is a value in the watch table, highlighted if it changed.
+type watchCell struct {
+	value     string
+	highlight bool
+}
+
+func (w *watcher) table(events []watchEvent) {
+	header := []string{"TIME", "IP", "POWER", "FLAME EFFECT", "FAN BOOST", "TARGET", "ROOM", "TREND"}
+	rows := make([][]watchCell, 0, len(events)+1)
+	headerRow := make([]watchCell, len(header))
+	for i, title := range header {
+		headerRow[i] = watchCell{value: title}
+	}
+	rows = append(rows, headerRow)
+
+	for _, e := range events {
+		if e.Error != "" {
+			rows = append(rows, []watchCell{
+				{value: e.Time.Format(time.TimeOnly)},
+				{value: e.IP},
+				{value: "error: " + e.Error},
+			})
+			continue
+		}
+
+		changed := make(map[string]bool, len(e.Changed))
+		for _, field := range e.Changed {
+			changed[field] = true
+		}
+		cell := func(field, value string) watchCell {
+			return watchCell{value: value, highlight: w.redraw && !e.first && changed[field]}
+		}
+
+		rows = append(rows, []watchCell{
+			{value: e.Time.Format(time.TimeOnly)},
+			{value: e.IP},
+			cell("power", formatBoolean(e.Power)),
+			cell("flame_effect", formatBoolean(e.FlameEffect)),
+			cell("fan_boost", formatBoolean(e.FanBoost)),
+			cell("target_temperature", fmt.Sprintf("%dºC", e.TargetTemperature)),
+			cell("room_temperature", fmt.Sprintf("%dºC", e.RoomTemperature)),
+			{value: trendArrow(e.Trend)},
+		})
+	}
+
+	// Size the columns by the values alone, the escape codes that highlight
+	// them take no room on screen. An error spans the columns after the IP.
+	widths := make([]int, len(header))
+	for _, row := range rows {
+		if len(row) < len(header) {
+			row = row[:len(row)-1]
+		}
+		for i, c := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(c.value))
+		}
+	}
+
+	for _, row := range rows {
+		var line strings.Builder
+		for i, c := range row {
+			value := c.value
+			if c.highlight {
+				value = ansiHighlight + value + ansiReset
+			}
+			line.WriteString(value)
+			if i < len(row)-1 {
+				line.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(c.value)+2))
+			}
+		}
+		fmt.Fprintln(w.out, line.String())
+	}
+}
+
+// changedFields returns the names of the fields that differ between a and b,
+// using the same names as the JSON output.
+func changedFields(a, b statusResult) []string {
+	changed := make([]string, 0)
+	if a.Power != b.Power {
+		changed = append(changed, "power")
+	}
+	if a.FlameEffect != b.FlameEffect {
+		changed = append(changed, "flame_effect")
+	}
+	if a.FanBoost != b.FanBoost {
+		changed = append(changed, "fan_boost")
+	}
+	if a.TargetTemperature != b.TargetTemperature {
+		changed = append(changed, "target_temperature")
+	}
+	if a.RoomTemperature != b.RoomTemperature {
+		changed = append(changed, "room_temperature")
+	}
+	return changed
+}
+
+// trend describes the direction the room temperature moved over the readings.
+func trend(readings []int) string {
+	if len(readings) < 2 {
+		return "steady"
+	}
+	switch delta := readings[len(readings)-1] - readings[0]; {
+	case delta > 0:
+		return "rising"
+	case delta < 0:
+		return "falling"
+	}
+	return "steady"
+}
+
+func trendArrow(trend string) string {
+	switch trend {
+	case "rising":
+		return "↑ rising"
+	case "falling":
+		return "↓ falling"
+	}
+	return "→ steady"
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangedFields(t *testing.T) {
+	base := statusResult{IP: "10.0.0.40", Power: true, TargetTemperature: 22, RoomTemperature: 19}
+
+	tests := []struct {
+		name   string
+		change func(*statusResult)
+		want   []string
+	}{
+		{"nothing", func(*statusResult) {}, []string{}},
+		{"power", func(s *statusResult) { s.Power = false }, []string{"power"}},
+		{"flame effect", func(s *statusResult) { s.FlameEffect = true }, []string{"flame_effect"}},
+		{"fan boost", func(s *statusResult) { s.FanBoost = true }, []string{"fan_boost"}},
+		{"target", func(s *statusResult) { s.TargetTemperature = 24 }, []string{"target_temperature"}},
+		{"room", func(s *statusResult) { s.RoomTemperature = 20 }, []string{"room_temperature"}},
+		{"several", func(s *statusResult) {
+			s.Power = false
+			s.RoomTemperature = 18
+		}, []string{"power", "room_temperature"}},
+		{"timers are not reported", func(s *statusResult) { s.HasTimers = true }, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := base
+			tt.change(&current)
+			got := changedFields(base, current)
+			assert.NotNil(t, got, "changed must encode as [] rather than null")
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTrend(t *testing.T) {
+	tests := []struct {
+		name     string
+		readings []int
+		want     string
+	}{
+		{"no readings", nil, "steady"},
+		{"one reading", []int{19}, "steady"},
+		{"unchanged", []int{19, 19, 19}, "steady"},
+		{"warming", []int{18, 19, 20}, "rising"},
+		{"cooling", []int{20, 19}, "falling"},
+		{"back where it started", []int{19, 21, 19}, "steady"},
+		{"net rise despite a dip", []int{18, 17, 19}, "rising"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, trend(tt.readings))
+		})
+	}
+}
+
+func TestWatchEventFieldNames(t *testing.T) {
+	event := watchEvent{
+		Time:         time.Date(2024, 6, 1, 20, 0, 0, 0, time.UTC),
+		statusResult: statusResult{IP: "10.0.0.40", Power: true, TargetTemperature: 22, RoomTemperature: 19},
+		Trend:        "rising",
+		Changed:      []string{},
+	}
+
+	data, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &fields))
+	assert.Equal(t, []string{
+		"changed", "fan_boost", "flame_effect", "has_timers", "ip", "power",
+		"room_temperature", "target_temperature", "time", "trend",
+	}, keys(fields))
+	assert.Equal(t, []interface{}{}, fields["changed"])
+
+	event.Error = "i/o timeout"
+	data, err = json.Marshal(event)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"error":"i/o timeout"`)
+}
+
+// TestWatchTableAlignment checks that highlighted cells do not push the
+// columns after them out of line.
+func TestWatchTableAlignment(t *testing.T) {
+	var out bytes.Buffer
+	w := &watcher{out: &out, redraw: true}
+	now := time.Date(2024, 6, 1, 20, 0, 0, 0, time.UTC)
+
+	w.table([]watchEvent{
+		{
+			Time:         now,
+			statusResult: statusResult{IP: "10.0.0.40", Power: true, TargetTemperature: 22, RoomTemperature: 19},
+			Trend:        "rising",
+			Changed:      []string{"power", "target_temperature"},
+		},
+		{
+			Time:         now,
+			statusResult: statusResult{IP: "10.0.0.141", TargetTemperature: 18, RoomTemperature: 17},
+			Trend:        "steady",
+			Changed:      []string{},
+		},
+	})
+
+	assert.Contains(t, out.String(), ansiHighlight+"On"+ansiReset)
+
+	ansi := regexp.MustCompile(`\033\[[0-9;]*m`)
+	lines := strings.Split(strings.TrimRight(ansi.ReplaceAllString(out.String(), ""), "\n"), "\n")
+	require.Len(t, lines, 3)
+
+	for _, title := range []string{"POWER", "TARGET", "ROOM", "TREND"} {
+		column := utf8.RuneCountInString(lines[0][:strings.Index(lines[0], title)])
+		for _, line := range lines[1:] {
+			runes := []rune(line)
+			require.Greater(t, len(runes), column, line)
+			assert.NotEqual(t, ' ', runes[column], "%s column of %q", title, line)
+			assert.Equal(t, ' ', runes[column-1], "%s column of %q", title, line)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Check returns every problem found in the configuration.
+func (c *Config) Check() []error {
+	var problems []error
+
+	for _, name := range c.FireplaceNames() {
+		f := c.Fireplaces[name]
+		if f.Serial == 0 {
+			problems = append(problems, fmt.Errorf("fireplaces.%s: serial is required", name))
+		}
+		if f.PIN == 0 {
+			problems = append(problems, fmt.Errorf("fireplaces.%s: pin is required", name))
+		}
+		if f.IP != "" && net.ParseIP(f.IP).To4() == nil {
+			problems = append(problems, fmt.Errorf("fireplaces.%s: ip %q is not an IPv4 address", name, f.IP))
+		}
+		for _, capability := range f.Capabilities {
+			if capability != CapabilityFlameEffect && capability != CapabilityFanBoost {
+				problems = append(problems, fmt.Errorf("fireplaces.%s: unknown capability %q", name, capability))
+			}
+		}
+	}
+
+	for _, group := range c.GroupNames() {
+		if len(c.Groups[group]) == 0 {
+			problems = append(problems, fmt.Errorf("groups.%s: has no fireplaces", group))
+		}
+		for _, name := range c.Groups[group] {
+			if _, err := c.Fireplace(name); err != nil {
+				problems = append(problems, fmt.Errorf("groups.%s: %w", group, err))
+			}
+		}
+	}
+
+	for _, name := range c.HomeKit.Fireplaces {
+		if _, err := c.Fireplace(name); err != nil {
+			problems = append(problems, fmt.Errorf("homekit.fireplaces: %w", err))
+		}
+	}
+
+	if c.HomeKit.HeatingHysteresis < 0 {
+		problems = append(problems, fmt.Errorf("homekit.heating_hysteresis must not be negative"))
+	}
+	if code := strings.ReplaceAll(c.HomeKit.SetupCode, "-", ""); code != "" && !isDigits(code, 8) {
+		problems = append(problems, fmt.Errorf("homekit.setup_code must have 8 digits"))
+	}
+	if !ValidHomeKitMode(c.HomeKit.Mode) {
+		problems = append(problems, fmt.Errorf("homekit.mode %q must be %s or %s", c.HomeKit.Mode, HomeKitModeThermostat, HomeKitModeHeaterCooler))
+	}
+	if c.HomeKit.Port < 0 || c.HomeKit.Port > 65535 {
+		problems = append(problems, fmt.Errorf("homekit.port must be between 0 and 65535"))
+	}
+
+	if c.Service.RefreshInterval < 0 {
+		problems = append(problems, fmt.Errorf("service.refresh_interval must not be negative"))
+	}
+	if c.Service.FaultThreshold < 0 {
+		problems = append(problems, fmt.Errorf("service.fault_threshold must not be negative"))
+	}
+
+	for _, name := range c.PresetNames() {
+		if err := c.Presets[name].Validate(); err != nil {
+			problems = append(problems, fmt.Errorf("presets.%s: %w", name, err))
+		}
+	}
+
+	return problems
+}
+
+// isDigits reports whether s is made of n decimal digits.
+func isDigits(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
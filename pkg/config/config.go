@@ -0,0 +1,281 @@
+// Package config loads and saves the firecontrol configuration file.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrPresetNotFound    = errors.New("preset not found")
+	ErrFireplaceNotFound = errors.New("fireplace not found")
+	ErrGroupNotFound     = errors.New("group not found")
+	ErrEmptyGroup        = errors.New("group has no fireplaces")
+)
+
+// Capabilities a fireplace may have. A fireplace with no capabilities listed is
+// assumed to have them all.
+const (
+	CapabilityFlameEffect = "flame_effect"
+	CapabilityFanBoost    = "fan_boost"
+)
+
+// HomeKit services a fireplace can be exposed as.
+const (
+	HomeKitModeThermostat   = "thermostat"
+	HomeKitModeHeaterCooler = "heater-cooler"
+)
+
+// Preset is a named set of fireplace settings, such as "cozy" for on at 23ºC
+// with the flame effect on.
+type Preset = firecontrol.DesiredState
+
+// Config is the contents of the configuration file.
+type Config struct {
+	Fireplaces map[string]Fireplace `yaml:"fireplaces,omitempty"`
+	Groups     map[string][]string  `yaml:"groups,omitempty"`
+	HomeKit    HomeKit              `yaml:"homekit,omitempty"`
+	Service    Service              `yaml:"service,omitempty"`
+	Presets    map[string]Preset    `yaml:"presets,omitempty"`
+}
+
+// Fireplace identifies a fireplace by the serial number and PIN found inside
+// its remote control. Fireplaces without an IP are found by searching the
+// network.
+type Fireplace struct {
+	Serial       uint32   `yaml:"serial"`
+	PIN          uint16   `yaml:"pin"`
+	IP           string   `yaml:"ip,omitempty"`
+	Capabilities []string `yaml:"capabilities,omitempty"`
+}
+
+// HomeKit configures the HomeKit accessory.
+type HomeKit struct {
+	// Fireplaces lists the names of the fireplaces to expose, all configured
+	// fireplaces are exposed if empty.
+	Fireplaces []string `yaml:"fireplaces,omitempty"`
+
+	// Bridge exposes the fireplaces behind a HomeKit bridge. A bridge is always
+	// used when more than one fireplace is exposed.
+	Bridge bool `yaml:"bridge,omitempty"`
+
+	// HeatingHysteresis is how many degrees below the target temperature the
+	// room must fall before an idling fireplace is shown as heating again.
+	HeatingHysteresis int `yaml:"heating_hysteresis,omitempty"`
+
+	// Name is the name shown in the Home app for the bridge, or for the
+	// fireplace when only one is exposed without a bridge.
+	Name string `yaml:"name,omitempty"`
+
+	// StorageDir is where pairings and keys are kept, ./db if empty. Each
+	// instance on a host needs its own.
+	StorageDir string `yaml:"storage_dir,omitempty"`
+
+	// SetupCode is the 8 digit code used to pair, with or without dashes. A
+	// random code is generated and kept in StorageDir if empty.
+	SetupCode string `yaml:"setup_code,omitempty"`
+
+	// Port is the port the HomeKit server listens on, a free one if zero.
+	Port int `yaml:"port,omitempty"`
+
+	// Interface is the network interface the HomeKit server listens and
+	// advertises on, every interface if empty.
+	Interface string `yaml:"interface,omitempty"`
+
+	// TemperatureSensor adds a temperature sensor showing the room temperature
+	// to each fireplace, so automations can be triggered by it.
+	TemperatureSensor bool `yaml:"temperature_sensor,omitempty"`
+
+	// Mode is the service fireplaces are exposed as, HomeKitModeThermostat if
+	// empty.
+	Mode string `yaml:"mode,omitempty"`
+}
+
+// ValidHomeKitMode reports whether fireplaces can be exposed as mode. The empty
+// mode stands for HomeKitModeThermostat.
+func ValidHomeKitMode(mode string) bool {
+	switch mode {
+	case "", HomeKitModeThermostat, HomeKitModeHeaterCooler:
+		return true
+	}
+	return false
+}
+
+// Service configures long running commands such as the HomeKit accessory.
+type Service struct {
+	// RefreshInterval is how often fireplace status is polled.
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+
+	// FaultThreshold is how many refreshes in a row must fail before HomeKit is
+	// told the fireplace is not responding.
+	FaultThreshold int `yaml:"fault_threshold,omitempty"`
+}
+
+// Has reports whether the fireplace has the given capability.
+func (f Fireplace) Has(capability string) bool {
+	if len(f.Capabilities) == 0 {
+		return true
+	}
+	for _, c := range f.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultPath returns the configuration file used when none is given,
+// $XDG_CONFIG_HOME/firecontrol/config.yaml or its platform equivalent.
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "firecontrol.yaml"
+	}
+	return filepath.Join(dir, "firecontrol", "config.yaml")
+}
+
+// Load reads the configuration file at path and applies any overrides from the
+// environment. A missing file is an empty configuration.
+func Load(path string) (*Config, error) {
+	cfg, err := Read(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.applyEnv(os.Environ()); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Read reads the configuration file at path without applying environment
+// overrides, for editing and saving back. A missing file is an empty
+// configuration.
+func Read(path string) (*Config, error) {
+	cfg := &Config{}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Save writes the configuration to path, creating its directory if needed.
+func (c *Config) Save(path string) error {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+// Fireplace returns the fireplace with the given name, ignoring case.
+func (c *Config) Fireplace(name string) (Fireplace, error) {
+	for n, f := range c.Fireplaces {
+		if strings.EqualFold(n, name) {
+			return f, nil
+		}
+	}
+	return Fireplace{}, fmt.Errorf("%w: %s", ErrFireplaceNotFound, name)
+}
+
+// FireplaceNames returns the names of all fireplaces in alphabetical order.
+func (c *Config) FireplaceNames() []string {
+	return sortedKeys(c.Fireplaces)
+}
+
+// Group returns the names of the fireplaces in the group with the given name,
+// ignoring case. A group without fireplaces is an error, so it is never
+// mistaken for every fireplace.
+func (c *Config) Group(name string) ([]string, error) {
+	for n, members := range c.Groups {
+		if strings.EqualFold(n, name) {
+			if len(members) == 0 {
+				return nil, fmt.Errorf("%w: %s", ErrEmptyGroup, n)
+			}
+			return members, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrGroupNotFound, name)
+}
+
+// GroupNames returns the names of all groups in alphabetical order.
+func (c *Config) GroupNames() []string {
+	return sortedKeys(c.Groups)
+}
+
+// HomeKitFireplaces returns the names of the fireplaces the HomeKit accessory
+// should expose.
+func (c *Config) HomeKitFireplaces() []string {
+	if len(c.HomeKit.Fireplaces) > 0 {
+		return c.HomeKit.Fireplaces
+	}
+	return c.FireplaceNames()
+}
+
+// Preset returns the preset with the given name, ignoring case.
+func (c *Config) Preset(name string) (Preset, error) {
+	for n, p := range c.Presets {
+		if strings.EqualFold(n, name) {
+			return p, nil
+		}
+	}
+	return Preset{}, fmt.Errorf("%w: %s", ErrPresetNotFound, name)
+}
+
+// SetPreset adds or replaces the preset with the given name.
+func (c *Config) SetPreset(name string, p Preset) {
+	c.DeletePreset(name)
+	if c.Presets == nil {
+		c.Presets = make(map[string]Preset)
+	}
+	c.Presets[name] = p
+}
+
+// DeletePreset removes the preset with the given name, ignoring case.
+func (c *Config) DeletePreset(name string) error {
+	for n := range c.Presets {
+		if strings.EqualFold(n, name) {
+			delete(c.Presets, n)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrPresetNotFound, name)
+}
+
+// PresetNames returns the names of all presets in alphabetical order.
+func (c *Config) PresetNames() []string {
+	return sortedKeys(c.Presets)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for n := range m {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMissingFile(t *testing.T) {
+	r := require.New(t)
+
+	cfg, err := Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	r.NoError(err)
+	r.Empty(cfg.Presets)
+}
+
+func TestPresets(t *testing.T) {
+	a := assert.New(t)
+	r := require.New(t)
+
+	on, temp := true, 23
+	path := filepath.Join(t.TempDir(), "firecontrol", "config.yaml")
+
+	cfg := &Config{}
+	cfg.SetPreset("Cozy", Preset{Power: &on, TargetTemperature: &temp, FlameEffect: &on})
+	cfg.SetPreset("eco", Preset{Power: &on})
+	r.NoError(cfg.Save(path))
+
+	loaded, err := Load(path)
+	r.NoError(err)
+	a.Equal([]string{"Cozy", "eco"}, loaded.PresetNames())
+
+	cozy, err := loaded.Preset("cozy")
+	r.NoError(err)
+	a.Equal(23, *cozy.TargetTemperature)
+
+	// Replacing a preset with a different case keeps a single entry.
+	loaded.SetPreset("COZY", Preset{Power: &on})
+	a.Equal([]string{"COZY", "eco"}, loaded.PresetNames())
+
+	a.NoError(loaded.DeletePreset("eco"))
+	a.ErrorIs(loaded.DeletePreset("eco"), ErrPresetNotFound)
+	_, err = loaded.Preset("eco")
+	a.ErrorIs(err, ErrPresetNotFound)
+}
+
+func TestLoadFireplaces(t *testing.T) {
+	a := assert.New(t)
+	r := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	r.NoError(os.WriteFile(path, []byte(`
+fireplaces:
+  Lounge:
+    serial: 107757
+    pin: 1790
+    ip: 10.0.0.40
+  family-room:
+    serial: 107758
+    pin: 1791
+    capabilities: [flame_effect]
+homekit:
+  fireplaces: [lounge]
+service:
+  refresh_interval: 1m
+`), 0o644))
+
+	cfg, err := Load(path)
+	r.NoError(err)
+	a.Empty(cfg.Check())
+	a.Equal([]string{"Lounge", "family-room"}, cfg.FireplaceNames())
+	a.Equal([]string{"lounge"}, cfg.HomeKitFireplaces())
+	a.Equal(time.Minute, cfg.Service.RefreshInterval)
+
+	lounge, err := cfg.Fireplace("lounge")
+	r.NoError(err)
+	a.Equal(uint32(107757), lounge.Serial)
+	a.True(lounge.Has(CapabilityFanBoost))
+
+	family, err := cfg.Fireplace("family-room")
+	r.NoError(err)
+	a.True(family.Has(CapabilityFlameEffect))
+	a.False(family.Has(CapabilityFanBoost))
+}
+
+func TestApplyEnv(t *testing.T) {
+	a := assert.New(t)
+	r := require.New(t)
+
+	cfg := &Config{Fireplaces: map[string]Fireplace{
+		"family-room": {Serial: 1, PIN: 2},
+	}}
+
+	r.NoError(cfg.applyEnv([]string{
+		"HOME=/root",
+		"FIRECONTROL_FIREPLACE_FAMILY_ROOM_IP=10.0.0.41",
+		"FIRECONTROL_FIREPLACE_LOUNGE_SERIAL=107757",
+		"FIRECONTROL_FIREPLACE_LOUNGE_PIN=1790",
+		"FIRECONTROL_HOMEKIT_FIREPLACES=lounge, family-room",
+		"FIRECONTROL_REFRESH_INTERVAL=10s",
+	}))
+
+	a.Equal(Fireplace{Serial: 1, PIN: 2, IP: "10.0.0.41"}, cfg.Fireplaces["family-room"])
+	a.Equal(Fireplace{Serial: 107757, PIN: 1790}, cfg.Fireplaces["lounge"])
+	a.Equal([]string{"lounge", "family-room"}, cfg.HomeKit.Fireplaces)
+	a.Equal(10*time.Second, cfg.Service.RefreshInterval)
+
+	a.Error(cfg.applyEnv([]string{"FIRECONTROL_FIREPLACE_LOUNGE_PIN=lots"}))
+}
+
+func TestCheck(t *testing.T) {
+	a := assert.New(t)
+
+	hot := 40
+	cfg := &Config{
+		Fireplaces: map[string]Fireplace{
+			"lounge": {Serial: 1, IP: "not-an-ip", Capabilities: []string{"jets"}},
+		},
+		HomeKit: HomeKit{Fireplaces: []string{"garage"}, SetupCode: "123-45", Port: 70000, Mode: "fan"},
+		Presets: map[string]Preset{"inferno": {TargetTemperature: &hot}},
+	}
+
+	var messages []string
+	for _, err := range cfg.Check() {
+		messages = append(messages, err.Error())
+	}
+	a.Equal([]string{
+		"fireplaces.lounge: pin is required",
+		`fireplaces.lounge: ip "not-an-ip" is not an IPv4 address`,
+		`fireplaces.lounge: unknown capability "jets"`,
+		"homekit.fireplaces: fireplace not found: garage",
+		"homekit.setup_code must have 8 digits",
+		`homekit.mode "fan" must be thermostat or heater-cooler`,
+		"homekit.port must be between 0 and 65535",
+		"presets.inferno: invalid temperature",
+	}, messages)
+}
+
+func TestValidHomeKitMode(t *testing.T) {
+	a := assert.New(t)
+
+	a.True(ValidHomeKitMode(""))
+	a.True(ValidHomeKitMode(HomeKitModeThermostat))
+	a.True(ValidHomeKitMode(HomeKitModeHeaterCooler))
+	a.False(ValidHomeKitMode("heatercooler"))
+}
+
+func TestGroups(t *testing.T) {
+	a := assert.New(t)
+	r := require.New(t)
+
+	cfg := &Config{
+		Fireplaces: map[string]Fireplace{
+			"lounge":      {Serial: 1, PIN: 2},
+			"family-room": {Serial: 3, PIN: 4},
+		},
+		Groups: map[string][]string{
+			"Bedtime": {"lounge", "family-room"},
+			"garage":  {"garage"},
+			"empty":   {},
+		},
+	}
+
+	members, err := cfg.Group("bedtime")
+	r.NoError(err)
+	a.Equal([]string{"lounge", "family-room"}, members)
+	a.Equal([]string{"Bedtime", "empty", "garage"}, cfg.GroupNames())
+
+	_, err = cfg.Group("upstairs")
+	a.ErrorIs(err, ErrGroupNotFound)
+
+	_, err = cfg.Group("EMPTY")
+	a.ErrorIs(err, ErrEmptyGroup)
+	a.EqualError(err, "group has no fireplaces: empty")
+
+	var messages []string
+	for _, err := range cfg.Check() {
+		messages = append(messages, err.Error())
+	}
+	a.Equal([]string{
+		"groups.empty: has no fireplaces",
+		"groups.garage: fireplace not found: garage",
+	}, messages)
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefix is the prefix of every environment variable that overrides the
+// configuration file:
+//
+//	FIRECONTROL_FIREPLACE_<NAME>_SERIAL, _PIN and _IP override or add a fireplace
+//	FIRECONTROL_HOMEKIT_FIREPLACES is a comma separated list of fireplace names
+//	FIRECONTROL_REFRESH_INTERVAL is a duration such as 30s
+const envPrefix = "FIRECONTROL_"
+
+// applyEnv applies overrides from environ, given as KEY=value pairs.
+func (c *Config) applyEnv(environ []string) error {
+	for _, kv := range environ {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(key, envPrefix) {
+			continue
+		}
+		key = strings.TrimPrefix(key, envPrefix)
+
+		var err error
+		switch {
+		case key == "HOMEKIT_FIREPLACES":
+			c.HomeKit.Fireplaces = splitList(value)
+		case key == "REFRESH_INTERVAL":
+			c.Service.RefreshInterval, err = time.ParseDuration(value)
+		case strings.HasPrefix(key, "FIREPLACE_"):
+			err = c.applyFireplaceEnv(strings.TrimPrefix(key, "FIREPLACE_"), value)
+		}
+		if err != nil {
+			return fmt.Errorf("%s%s: %w", envPrefix, key, err)
+		}
+	}
+	return nil
+}
+
+func (c *Config) applyFireplaceEnv(key, value string) error {
+	i := strings.LastIndex(key, "_")
+	if i <= 0 {
+		return nil
+	}
+	envName, field := key[:i], key[i+1:]
+
+	name := strings.ToLower(envName)
+	for n := range c.Fireplaces {
+		if envKey(n) == envName {
+			name = n
+			break
+		}
+	}
+
+	if c.Fireplaces == nil {
+		c.Fireplaces = make(map[string]Fireplace)
+	}
+	f := c.Fireplaces[name]
+
+	switch field {
+	case "SERIAL":
+		serial, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return err
+		}
+		f.Serial = uint32(serial)
+	case "PIN":
+		pin, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return err
+		}
+		f.PIN = uint16(pin)
+	case "IP":
+		f.IP = value
+	default:
+		return nil
+	}
+
+	c.Fireplaces[name] = f
+	return nil
+}
+
+// envKey returns the form of a fireplace name used in environment variables.
+func envKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
+)
+
+// Locate returns a firecontrol.Fireplace for each of the named fireplaces, in
+// the same order. Fireplaces with a static IP are used as configured, the
+// network is searched for the rest.
+func (c *Config) Locate(names []string, opts ...firecontrol.Option) ([]*firecontrol.Fireplace, error) {
+	located, errs := c.LocateEach(names, opts...)
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return located, nil
+}
+
+// LocateEach is like Locate but reports an error for each fireplace that could
+// not be found instead of failing outright. Both slices are in the same order
+// as names, with a nil fireplace wherever there is an error.
+func (c *Config) LocateEach(names []string, opts ...firecontrol.Option) ([]*firecontrol.Fireplace, []error) {
+	located := make([]*firecontrol.Fireplace, len(names))
+	errs := make([]error, len(names))
+
+	var found []*firecontrol.Fireplace
+	var searchErr error
+	searched := false
+
+	for i, name := range names {
+		f, err := c.Fireplace(name)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		if f.IP != "" {
+			ip := net.ParseIP(f.IP)
+			if ip == nil {
+				errs[i] = fmt.Errorf("fireplace %s: invalid ip %q", name, f.IP)
+				continue
+			}
+			fp := firecontrol.NewFireplace(ip, opts...)
+			fp.Serial, fp.PIN = f.Serial, f.PIN
+			located[i] = fp
+			continue
+		}
+
+		if !searched {
+			found, searchErr = firecontrol.SearchForFireplaces()
+			searched = true
+		}
+		if searchErr != nil {
+			errs[i] = searchErr
+			continue
+		}
+
+		for _, fp := range found {
+			if fp.Serial == f.Serial {
+				fp.Configure(opts...)
+				located[i] = fp
+				break
+			}
+		}
+		if located[i] == nil {
+			errs[i] = fmt.Errorf("fireplace %s (serial %d) was not found on the network", name, f.Serial)
+		}
+	}
+
+	return located, errs
+}
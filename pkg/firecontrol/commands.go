@@ -1,16 +1,28 @@
 package firecontrol
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"net"
+)
 
 type (
-	PowerOnAck  struct{}
-	PowerOffAck struct{}
-	SetTempAck  struct{}
+	PowerOnAck        struct{}
+	PowerOffAck       struct{}
+	SetTempAck        struct{}
+	FanBoostOnAck     struct{}
+	FanBoostOffAck    struct{}
+	FlameEffectOnAck  struct{}
+	FlameEffectOffAck struct{}
 )
 
-func (f *PowerOnAck) isFireplaceData()  {}
-func (f *PowerOffAck) isFireplaceData() {}
-func (f *SetTempAck) isFireplaceData()  {}
+func (f *PowerOnAck) isFireplaceData()        {}
+func (f *PowerOffAck) isFireplaceData()       {}
+func (f *SetTempAck) isFireplaceData()        {}
+func (f *FanBoostOnAck) isFireplaceData()     {}
+func (f *FanBoostOffAck) isFireplaceData()    {}
+func (f *FlameEffectOnAck) isFireplaceData()  {}
+func (f *FlameEffectOffAck) isFireplaceData() {}
 
 func (f *Fireplace) PowerOn() error {
 	data, err := f.rpc(CommandPowerOn, nil)
@@ -20,7 +32,7 @@ func (f *Fireplace) PowerOn() error {
 
 	_, ok := data.(*PowerOnAck)
 	if !ok {
-		return fmt.Errorf("unexpected data type: %T", data)
+		return fmt.Errorf("%w: %T", ErrUnexpectedResponse, data)
 	}
 
 	return nil
@@ -34,7 +46,7 @@ func (f *Fireplace) PowerOff() error {
 
 	_, ok := data.(*PowerOffAck)
 	if !ok {
-		return fmt.Errorf("unexpected data type: %T", data)
+		return fmt.Errorf("%w: %T", ErrUnexpectedResponse, data)
 	}
 
 	return nil
@@ -49,15 +61,45 @@ func (f *Fireplace) Refresh() error {
 
 	status, ok := data.(*Status)
 	if !ok {
-		return fmt.Errorf("unexpected data type: %T", data)
+		return fmt.Errorf("%w: %T", ErrUnexpectedResponse, data)
 	}
 
 	f.Status = status
 	return nil
 }
 
+// Identify asks the fireplace at f.Addr which fireplace it is, searching it
+// directly rather than broadcasting. If f.Serial is set, any other answer is an
+// ErrWrongFireplace, otherwise Serial and PIN are filled in from the answer. A
+// fireplace that ignores searches sent to it directly is asked for its status
+// instead, which only shows that a fireplace is answering.
+func (f *Fireplace) Identify() error {
+	data, err := f.rpc(CommandSearchForFireplaces, nil)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return f.Refresh()
+	}
+	if err != nil {
+		return err
+	}
+
+	found, ok := data.(*foundFireplacePayload)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrUnexpectedResponse, data)
+	}
+	if f.Serial != 0 && found.Serial != f.Serial {
+		return fmt.Errorf("%w: found serial %d, expected %d", ErrWrongFireplace, found.Serial, f.Serial)
+	}
+
+	f.Serial = found.Serial
+	if f.PIN == 0 {
+		f.PIN = found.PIN
+	}
+	return nil
+}
+
 func (f *Fireplace) SetTemperature(newTemp int) error {
-	if newTemp < minTemperature || newTemp > maxTemperature {
+	if newTemp < MinTemperature || newTemp > MaxTemperature {
 		return ErrInvalidTemperature
 	}
 
@@ -68,8 +110,46 @@ func (f *Fireplace) SetTemperature(newTemp int) error {
 
 	_, ok := data.(*SetTempAck)
 	if !ok {
-		return fmt.Errorf("unexpected data type: %T", data)
+		return fmt.Errorf("%w: %T", ErrUnexpectedResponse, data)
 	}
 
 	return nil
 }
+
+// SetFlameEffect turns the flame effect on or off
+func (f *Fireplace) SetFlameEffect(on bool) error {
+	command := CommandFlameEffectOff
+	if on {
+		command = CommandFlameEffectOn
+	}
+
+	data, err := f.rpc(command, nil)
+	if err != nil {
+		return err
+	}
+
+	switch data.(type) {
+	case *FlameEffectOnAck, *FlameEffectOffAck:
+		return nil
+	}
+	return fmt.Errorf("%w: %T", ErrUnexpectedResponse, data)
+}
+
+// SetFanBoost turns the fan boost on or off
+func (f *Fireplace) SetFanBoost(on bool) error {
+	command := CommandFanBoostOff
+	if on {
+		command = CommandFanBoostOn
+	}
+
+	data, err := f.rpc(command, nil)
+	if err != nil {
+		return err
+	}
+
+	switch data.(type) {
+	case *FanBoostOnAck, *FanBoostOffAck:
+		return nil
+	}
+	return fmt.Errorf("%w: %T", ErrUnexpectedResponse, data)
+}
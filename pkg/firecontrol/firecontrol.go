@@ -2,12 +2,12 @@ package firecontrol
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net"
-	"net/netip"
 	"time"
 )
 
@@ -26,6 +26,9 @@ type Fireplace struct {
 	PIN    uint16
 	Status *Status
 	Addr   *net.UDPAddr
+
+	dryRun  io.Writer
+	limiter *RateLimiter
 }
 
 type FireplaceData interface {
@@ -51,6 +54,18 @@ func (f *foundFireplacePayload) isFireplaceData() {}
 
 var ErrInvalidTemperature = errors.New("invalid temperature")
 var ErrInvalidResponse = errors.New("invalid response packet")
+var ErrDataTooLarge = errors.New("data size too large")
+var ErrUnexpectedResponse = errors.New("unexpected response")
+
+// ErrWrongFireplace is returned by Identify when a fireplace other than the
+// expected one answers at the address.
+var ErrWrongFireplace = errors.New("a different fireplace answered")
+
+// Temperature range for the fireplace based on v0.3 of spec
+const (
+	MinTemperature = 3
+	MaxTemperature = 31
+)
 
 type CommandCode uint8
 
@@ -81,10 +96,6 @@ const (
 	startByte  = 0x47
 	endByte    = 0x46
 
-	// Temperature range for the fireplace based on v0.3 of spec
-	minTemperature = 3
-	maxTemperature = 31
-
 	// Fireplace broadcast port
 	fireplacePort = 3300
 
@@ -92,10 +103,64 @@ const (
 	maxDataSize = 10
 )
 
-func NewFireplace(addr net.IP) *Fireplace {
-	return &Fireplace{
+var commandNames = map[CommandCode]string{
+	CommandStatusPlease:        "StatusPlease",
+	CommandPowerOn:             "PowerOn",
+	CommandPowerOff:            "PowerOff",
+	CommandSearchForFireplaces: "SearchForFireplaces",
+	CommandFanBoostOn:          "FanBoostOn",
+	CommandFanBoostOff:         "FanBoostOff",
+	CommandFlameEffectOn:       "FlameEffectOn",
+	CommandFlameEffectOff:      "FlameEffectOff",
+	CommandSetTemperature:      "SetTemperature",
+
+	ResponseStatus:            "Status",
+	ResponsePowerOnAck:        "PowerOnAck",
+	ResponsePowerOffAck:       "PowerOffAck",
+	ResponseFanBoostOnAck:     "FanBoostOnAck",
+	ResponseFanBoostOffAck:    "FanBoostOffAck",
+	ResponseFlameEffectOnAck:  "FlameEffectOnAck",
+	ResponseFlameEffectOffAck: "FlameEffectOffAck",
+	ResponseTemperatureAck:    "TemperatureAck",
+	ResponseIAmAFire:          "IAmAFire",
+}
+
+func (c CommandCode) String() string {
+	if name, ok := commandNames[c]; ok {
+		return fmt.Sprintf("%s(0x%02X)", name, uint8(c))
+	}
+	return fmt.Sprintf("Unknown(0x%02X)", uint8(c))
+}
+
+// IsRemoteCommand reports whether c is one of the commands a remote controller
+// is documented to send to a fireplace.
+func (c CommandCode) IsRemoteCommand() bool {
+	switch c {
+	case CommandStatusPlease, CommandPowerOn, CommandPowerOff,
+		CommandSearchForFireplaces, CommandFanBoostOn, CommandFanBoostOff,
+		CommandFlameEffectOn, CommandFlameEffectOff, CommandSetTemperature:
+		return true
+	}
+	return false
+}
+
+func NewFireplace(addr net.IP, opts ...Option) *Fireplace {
+	f := &Fireplace{
 		Addr: &net.UDPAddr{IP: addr, Port: fireplacePort},
 	}
+	f.Configure(opts...)
+	return f
+}
+
+// listenUDP listens on the fireplace port, sharing it with any other sockets in
+// this process that have done the same.
+func listenUDP(ctx context.Context, addr *net.UDPAddr) (*net.UDPConn, error) {
+	lc := net.ListenConfig{Control: reusePort}
+	conn, err := lc.ListenPacket(ctx, "udp4", addr.String())
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
 }
 
 func SearchForFireplaces() ([]*Fireplace, error) {
@@ -104,22 +169,17 @@ func SearchForFireplaces() ([]*Fireplace, error) {
 		&net.UDPAddr{Port: fireplacePort, IP: net.IPv4bcast},
 	)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer conn.Close()
 
-	listenAddr := net.UDPAddrFromAddrPort(netip.AddrPortFrom(netip.IPv4Unspecified(), fireplacePort))
-
-	// Create a UDP connection to listen for incoming packets
-	listener, err := net.ListenUDP("udp4", listenAddr)
+	// Replies arrive on the fireplace port, which is shared with anything else
+	// in this process listening there.
+	frames, cancel, err := sharedListener.subscribe()
 	if err != nil {
-		fmt.Println("Error listening on UDP:", err)
 		return nil, err
 	}
-	defer listener.Close()
-
-	listener.SetDeadline(time.Now().Add(3 * time.Second))
-	listener.SetReadBuffer(readBufferSize)
+	defer cancel()
 
 	// Send search command
 	searchPacket := marshalCommandPacket(CommandSearchForFireplaces, []byte{})
@@ -129,45 +189,38 @@ func SearchForFireplaces() ([]*Fireplace, error) {
 	}
 
 	// Wait for responses
+	deadline := time.NewTimer(3 * time.Second)
+	defer deadline.Stop()
+
 	fireplaces := make([]*Fireplace, 0)
 	for {
-		buffer := make([]byte, readBufferSize)
-		n, remoteAddr, err := listener.ReadFromUDP(buffer)
-		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				break
-			}
-			log.Printf("Error reading from UDP: %v", err)
-			return nil, err
-		}
-
-		cmd, err := UnmarshalCommandPacket(buffer[:n])
-		if err != nil {
-			return nil, err
+		var f frame
+		select {
+		case <-deadline.C:
+			return fireplaces, nil
+		case f = <-frames:
 		}
 
-		if cmd.CommandID != ResponseIAmAFire {
+		if f.cmd.CommandID != ResponseIAmAFire {
 			continue
 		}
 
-		data, err := handleResponse(cmd)
+		data, err := handleResponse(f.cmd)
 		if err != nil {
 			return nil, err
 		}
 
 		fp, ok := data.(*foundFireplacePayload)
 		if !ok {
-			return nil, fmt.Errorf("unexpected data type: %T", data)
+			return nil, fmt.Errorf("%w: %T", ErrUnexpectedResponse, data)
 		}
 
 		fireplaces = append(fireplaces, &Fireplace{
 			Serial: fp.Serial,
 			PIN:    fp.PIN,
-			Addr:   remoteAddr,
+			Addr:   f.from,
 		})
 	}
-
-	return fireplaces, nil
 }
 
 type Command struct {
@@ -205,7 +258,7 @@ func marshalCommand(command CommandCode, data []byte) []byte {
 		panic("data size too large")
 	}
 
-	cmd.CRC = calculateCRC([]byte{byte(cmd.CommandID), cmd.DataSize})
+	cmd.CRC = calculateCRC(append([]byte{byte(cmd.CommandID), cmd.DataSize}, cmd.Data[:]...))
 	buf := new(bytes.Buffer)
 	binary.Write(buf, binary.BigEndian, cmd)
 	return buf.Bytes()
@@ -215,6 +268,15 @@ func marshalCommandPacket(command CommandCode, data []byte) []byte {
 	return marshalCommand(command, []byte(data))
 }
 
+// MarshalCommandPacket frames a command and its data into a packet ready to be
+// sent to a fireplace.
+func MarshalCommandPacket(command CommandCode, data []byte) ([]byte, error) {
+	if len(data) > maxDataSize {
+		return nil, ErrDataTooLarge
+	}
+	return marshalCommandPacket(command, data), nil
+}
+
 func UnmarshalCommandPacket(packet []byte) (*Command, error) {
 	if !isValidResponse(packet) {
 		return nil, ErrInvalidResponse
@@ -268,9 +330,21 @@ func handleResponse(command *Command) (FireplaceData, error) {
 	case ResponseTemperatureAck:
 		return &SetTempAck{}, nil
 
+	case ResponseFanBoostOnAck:
+		return &FanBoostOnAck{}, nil
+
+	case ResponseFanBoostOffAck:
+		return &FanBoostOffAck{}, nil
+
+	case ResponseFlameEffectOnAck:
+		return &FlameEffectOnAck{}, nil
+
+	case ResponseFlameEffectOffAck:
+		return &FlameEffectOffAck{}, nil
+
 	}
 
-	return nil, fmt.Errorf("unknown command ID: %d", command.CommandID)
+	return nil, fmt.Errorf("%w: unknown command ID: %d", ErrUnexpectedResponse, command.CommandID)
 }
 
 func parseFireplaceResponse(packet []byte) (Fireplace, error) {
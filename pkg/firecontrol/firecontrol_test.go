@@ -1,7 +1,9 @@
 package firecontrol
 
 import (
+	"bytes"
 	"encoding/hex"
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -110,3 +112,42 @@ func mustDecode(s string) []byte {
 	}
 	return b
 }
+
+func TestMarshalCommandPacket(t *testing.T) {
+	a := assert.New(t)
+
+	packet, err := MarshalCommandPacket(CommandSetTemperature, []byte{22})
+	a.NoError(err)
+	a.EqualValues(mustDecode("475701160000000000000000006e46"), packet)
+
+	_, err = MarshalCommandPacket(CommandSetTemperature, make([]byte, 11))
+	a.ErrorIs(err, ErrDataTooLarge)
+}
+
+func TestCommandCodeString(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("StatusPlease(0x31)", CommandStatusPlease.String())
+	a.Equal("Unknown(0x01)", CommandCode(0x01).String())
+	a.True(CommandPowerOn.IsRemoteCommand())
+	a.False(ResponseStatus.IsRemoteCommand())
+}
+
+func TestDryRun(t *testing.T) {
+	a := assert.New(t)
+
+	out := new(bytes.Buffer)
+	fp := NewFireplace(net.ParseIP("192.0.2.1"), WithDryRun(out))
+
+	a.NoError(fp.PowerOn())
+	a.NoError(fp.SetTemperature(22))
+	a.NoError(fp.SetFlameEffect(true))
+	a.NoError(fp.SetFanBoost(false))
+	a.Equal(
+		"dry-run: PowerOn(0x39) size=0 data= crc=0x39 -> 192.0.2.1:3300 [473900000000000000000000003946]\n"+
+			"dry-run: SetTemperature(0x57) size=1 data=16 crc=0x6E -> 192.0.2.1:3300 [475701160000000000000000006e46]\n"+
+			"dry-run: FlameEffectOn(0x56) size=0 data= crc=0x56 -> 192.0.2.1:3300 [475600000000000000000000005646]\n"+
+			"dry-run: FanBoostOff(0x38) size=0 data= crc=0x38 -> 192.0.2.1:3300 [473800000000000000000000003846]\n",
+		out.String(),
+	)
+}
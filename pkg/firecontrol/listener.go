@@ -0,0 +1,98 @@
+package firecontrol
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// frameBacklog is how many frames a subscriber can fall behind by before
+// further frames are dropped for it.
+const frameBacklog = 16
+
+// frame is a valid packet that arrived on the fireplace port.
+type frame struct {
+	cmd  *Command
+	from *net.UDPAddr
+}
+
+// portListener is the only socket in this process listening on the fireplace
+// port for frames that are not replies to a command, such as search replies
+// and frames from other controllers. The kernel shares datagrams out between
+// sockets listening on the same port rather than copying them to each, so
+// everything that needs those frames subscribes to this one listener instead
+// of opening its own.
+type portListener struct {
+	mu          sync.Mutex
+	conn        *net.UDPConn
+	subscribers map[chan frame]struct{}
+}
+
+var sharedListener = &portListener{}
+
+// subscribe returns a channel receiving every frame that arrives on the
+// fireplace port until cancel is called. The socket is opened for the first
+// subscriber and closed once the last has cancelled.
+func (l *portListener) subscribe() (frames <-chan frame, cancel func(), err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		conn, err := listenUDP(context.Background(), &net.UDPAddr{IP: net.IPv4zero, Port: fireplacePort})
+		if err != nil {
+			return nil, nil, err
+		}
+		conn.SetReadBuffer(readBufferSize)
+		l.conn = conn
+		l.subscribers = make(map[chan frame]struct{})
+		go l.read(conn)
+	}
+
+	ch := make(chan frame, frameBacklog)
+	l.subscribers[ch] = struct{}{}
+
+	var once sync.Once
+	cancel = func() {
+		once.Do(func() { l.unsubscribe(ch) })
+	}
+	return ch, cancel, nil
+}
+
+func (l *portListener) unsubscribe(ch chan frame) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.subscribers, ch)
+	if len(l.subscribers) == 0 && l.conn != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+}
+
+// read hands every valid frame received on conn to the subscribers until conn
+// is closed.
+func (l *portListener) read(conn *net.UDPConn) {
+	buffer := make([]byte, readBufferSize)
+	for {
+		n, from, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+
+		cmd, err := UnmarshalCommandPacket(buffer[:n])
+		if err != nil {
+			continue
+		}
+
+		l.mu.Lock()
+		if l.conn == conn {
+			for ch := range l.subscribers {
+				select {
+				case ch <- frame{cmd: cmd, from: from}:
+				default:
+				}
+			}
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,306 @@
+package firecontrol
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// ChangeSource identifies who made a change to a fireplace's settings.
+type ChangeSource string
+
+const (
+	// SourceRemote is a change made by the wall remote or the Escea app.
+	SourceRemote ChangeSource = "remote/app"
+	// SourceFirecontrol is a change made by a command sent from this process.
+	SourceFirecontrol ChangeSource = "firecontrol"
+)
+
+const (
+	defaultMonitorPollInterval = 30 * time.Second
+
+	// How long after seeing another controller's frame to wait before asking
+	// the fireplace for its status, giving it time to act on the command.
+	foreignFrameSettleDelay = 1 * time.Second
+
+	// How long commands are remembered for attributing changes.
+	journalRetention = 5 * time.Minute
+)
+
+// ExternalChange describes a change to a fireplace's settings noticed by a
+// Monitor, either from a frame sent by another controller or by comparing
+// successive statuses.
+type ExternalChange struct {
+	Fireplace *Fireplace
+	Source    ChangeSource
+	Previous  *Status
+	Current   *Status
+	At        time.Time
+
+	// Frame and From are set when a frame from another controller was seen
+	// since the previous status.
+	Frame *Command
+	From  *net.UDPAddr
+}
+
+// IsExternal reports whether the change was made by something other than
+// this process.
+func (c ExternalChange) IsExternal() bool {
+	return c.Source != SourceFirecontrol
+}
+
+// Monitor passively listens on the fireplace port for frames exchanged by other
+// controllers and polls the fireplace status, reporting every change to its
+// settings along with who most likely made it. A frame from another controller
+// prompts a status read straight away, so changes made with the remote or the
+// Escea app are noticed without waiting for the next poll.
+//
+// The monitor shares the fireplace port with searches in the same process
+// rather than opening a listener of its own, so it never takes their replies.
+type Monitor struct {
+	// PollInterval is how often the status is polled when nothing is heard on
+	// the network. Zero disables polling, for programs that already read the
+	// status regularly and pass it to Observe.
+	PollInterval time.Duration
+
+	// ReadStatus asks the fireplace for its status. By default the monitor
+	// refreshes its own copy of the fireplace; programs that already talk to
+	// the fireplace should route it through the same place, so their requests
+	// never overlap.
+	ReadStatus func() (*Status, error)
+
+	fireplace *Fireplace
+
+	mu       sync.Mutex
+	previous *Status
+	checked  time.Time
+	frame    *frame
+}
+
+// NewMonitor returns a Monitor for f. The monitor refreshes its own copy of the
+// fireplace so it can run alongside other users of f, sharing its rate limit.
+func NewMonitor(f *Fireplace) *Monitor {
+	m := &Monitor{
+		PollInterval: defaultMonitorPollInterval,
+		fireplace:    &Fireplace{Serial: f.Serial, PIN: f.PIN, Addr: f.Addr, limiter: f.limiter},
+	}
+	m.ReadStatus = func() (*Status, error) {
+		if err := m.fireplace.Refresh(); err != nil {
+			return nil, err
+		}
+		return m.fireplace.Status, nil
+	}
+	return m
+}
+
+// Run monitors the fireplace until ctx is cancelled, sending every change to
+// events.
+func (m *Monitor) Run(ctx context.Context, events chan<- ExternalChange) error {
+	frames, cancel, err := sharedListener.subscribe()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	var poll <-chan time.Time
+	if m.PollInterval > 0 {
+		ticker := time.NewTicker(m.PollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+
+		m.check(ctx, events)
+	}
+
+	local := localIPs()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-poll:
+			m.check(ctx, events)
+
+		case f := <-frames:
+			if local[f.from.IP.String()] || !m.foreign(f) {
+				continue
+			}
+
+			m.mu.Lock()
+			m.frame = &f
+			m.mu.Unlock()
+
+			if f.cmd.CommandID == ResponseStatus {
+				if data, err := handleResponse(f.cmd); err == nil {
+					m.emit(ctx, events, data.(*Status))
+				}
+				continue
+			}
+
+			select {
+			case <-time.After(foreignFrameSettleDelay):
+			case <-ctx.Done():
+				continue
+			}
+			m.check(ctx, events)
+		}
+	}
+}
+
+// foreign reports whether f is a frame between another controller and the
+// fireplace, rather than a reply to a search.
+func (m *Monitor) foreign(f frame) bool {
+	if f.cmd.CommandID == ResponseIAmAFire {
+		return false
+	}
+	if f.from.IP.Equal(m.fireplace.Addr.IP) {
+		return true
+	}
+	return f.cmd.CommandID.IsRemoteCommand()
+}
+
+func (m *Monitor) check(ctx context.Context, events chan<- ExternalChange) {
+	status, err := m.ReadStatus()
+	if err != nil {
+		return
+	}
+	m.emit(ctx, events, status)
+}
+
+func (m *Monitor) emit(ctx context.Context, events chan<- ExternalChange, status *Status) {
+	change, ok := m.Observe(status)
+	if !ok {
+		return
+	}
+
+	select {
+	case events <- change:
+	case <-ctx.Done():
+	}
+}
+
+// Observe compares status, however it was read, with the previous status
+// observed and returns the change if any setting differs. It is safe to call
+// while the monitor runs.
+func (m *Monitor) Observe(status *Status) (ExternalChange, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	previous, since, frame := m.previous, m.checked, m.frame
+	m.previous, m.checked, m.frame = status, time.Now(), nil
+
+	if previous == nil || !settingsChanged(previous, status) {
+		return ExternalChange{}, false
+	}
+
+	change := ExternalChange{
+		Fireplace: m.fireplace,
+		Source:    SourceRemote,
+		Previous:  previous,
+		Current:   status,
+		At:        m.checked,
+	}
+	if frame != nil {
+		change.Frame = frame.cmd
+		change.From = frame.from
+	}
+	if explainedBy(previous, status, journal.since(m.fireplace.Addr, since.Add(-timeout))) {
+		change.Source = SourceFirecontrol
+	}
+	return change, true
+}
+
+// settingsChanged reports whether anything a controller can change differs
+// between two statuses. The room temperature is not a setting.
+func settingsChanged(a, b *Status) bool {
+	return a.IsOn != b.IsOn ||
+		a.TargetTempertaure != b.TargetTempertaure ||
+		a.FlameEffectIsOn != b.FlameEffectIsOn ||
+		a.FanBoostIsOn != b.FanBoostIsOn
+}
+
+// explainedBy reports whether every setting that changed between previous and
+// current is accounted for by one of the given commands.
+func explainedBy(previous, current *Status, commands []CommandCode) bool {
+	sent := make(map[CommandCode]bool, len(commands))
+	for _, c := range commands {
+		sent[c] = true
+	}
+
+	if previous.IsOn != current.IsOn {
+		if current.IsOn && !sent[CommandPowerOn] || !current.IsOn && !sent[CommandPowerOff] {
+			return false
+		}
+	}
+	if previous.TargetTempertaure != current.TargetTempertaure && !sent[CommandSetTemperature] {
+		return false
+	}
+	if previous.FlameEffectIsOn != current.FlameEffectIsOn {
+		if current.FlameEffectIsOn && !sent[CommandFlameEffectOn] || !current.FlameEffectIsOn && !sent[CommandFlameEffectOff] {
+			return false
+		}
+	}
+	if previous.FanBoostIsOn != current.FanBoostIsOn {
+		if current.FanBoostIsOn && !sent[CommandFanBoostOn] || !current.FanBoostIsOn && !sent[CommandFanBoostOff] {
+			return false
+		}
+	}
+	return true
+}
+
+func localIPs() map[string]bool {
+	ips := make(map[string]bool)
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ips
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			ips[ipNet.IP.String()] = true
+		}
+	}
+	return ips
+}
+
+// commandJournal remembers the commands this process has sent to each
+// fireplace, so a Monitor can tell our changes apart from everyone else's.
+type commandJournal struct {
+	mu      sync.Mutex
+	entries map[string][]journalEntry
+}
+
+type journalEntry struct {
+	command CommandCode
+	at      time.Time
+}
+
+var journal = &commandJournal{entries: make(map[string][]journalEntry)}
+
+func (j *commandJournal) record(addr *net.UDPAddr, command CommandCode) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	key := addr.IP.String()
+	now := time.Now()
+	entries := j.entries[key][:0]
+	for _, e := range j.entries[key] {
+		if now.Sub(e.at) < journalRetention {
+			entries = append(entries, e)
+		}
+	}
+	j.entries[key] = append(entries, journalEntry{command: command, at: now})
+}
+
+// since returns the commands sent to addr at or after t.
+func (j *commandJournal) since(addr *net.UDPAddr, t time.Time) []CommandCode {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var commands []CommandCode
+	for _, e := range j.entries[addr.IP.String()] {
+		if !e.at.Before(t) {
+			commands = append(commands, e.command)
+		}
+	}
+	return commands
+}
@@ -0,0 +1,160 @@
+package firecontrol
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplainedBy(t *testing.T) {
+	a := assert.New(t)
+
+	off := &Status{TargetTempertaure: 20}
+	on := &Status{IsOn: true, TargetTempertaure: 22}
+
+	a.True(explainedBy(off, on, []CommandCode{CommandSetTemperature, CommandPowerOn}))
+	a.False(explainedBy(off, on, []CommandCode{CommandPowerOn}))
+	a.False(explainedBy(off, on, nil))
+	a.False(explainedBy(on, off, []CommandCode{CommandPowerOn, CommandSetTemperature}))
+	a.True(explainedBy(on, &Status{IsOn: true, TargetTempertaure: 22, FlameEffectIsOn: true}, []CommandCode{CommandFlameEffectOn}))
+}
+
+func TestSettingsChanged(t *testing.T) {
+	a := assert.New(t)
+
+	a.False(settingsChanged(&Status{CurrentTemperature: 18}, &Status{CurrentTemperature: 19}))
+	a.True(settingsChanged(&Status{}, &Status{FanBoostIsOn: true}))
+}
+
+func TestCommandJournal(t *testing.T) {
+	a := assert.New(t)
+
+	j := &commandJournal{entries: make(map[string][]journalEntry)}
+	addr := &net.UDPAddr{IP: net.ParseIP("10.0.0.40"), Port: fireplacePort}
+	other := &net.UDPAddr{IP: net.ParseIP("10.0.0.41"), Port: fireplacePort}
+
+	start := time.Now()
+	j.record(addr, CommandPowerOn)
+
+	a.Equal([]CommandCode{CommandPowerOn}, j.since(addr, start))
+	a.Empty(j.since(other, start))
+	a.Empty(j.since(addr, time.Now().Add(time.Second)))
+}
+
+func TestMonitorObserve(t *testing.T) {
+	a := assert.New(t)
+
+	saved := journal
+	journal = &commandJournal{entries: make(map[string][]journalEntry)}
+	defer func() { journal = saved }()
+
+	m := NewMonitor(NewFireplace(net.ParseIP("10.0.0.50")))
+
+	_, changed := m.Observe(&Status{TargetTempertaure: 20})
+	a.False(changed, "the first status has nothing to compare with")
+	_, changed = m.Observe(&Status{TargetTempertaure: 20, CurrentTemperature: 18})
+	a.False(changed)
+
+	change, changed := m.Observe(&Status{TargetTempertaure: 22})
+	a.True(changed)
+	a.Equal(SourceRemote, change.Source)
+	a.EqualValues(20, change.Previous.TargetTempertaure)
+	a.Nil(change.Frame)
+
+	journal.record(m.fireplace.Addr, CommandSetTemperature)
+	change, changed = m.Observe(&Status{TargetTempertaure: 24})
+	a.True(changed)
+	a.Equal(SourceFirecontrol, change.Source)
+}
+
+// sendFrame sends a frame to the fireplace port on the loopback interface from
+// from, which is not one of this host's interface addresses.
+func sendFrame(t *testing.T, from string, command CommandCode, data []byte) {
+	t.Helper()
+
+	conn, err := net.DialUDP("udp4",
+		&net.UDPAddr{IP: net.ParseIP(from)},
+		&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: fireplacePort},
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write(marshalCommandPacket(command, data))
+	require.NoError(t, err)
+}
+
+func TestPortListenerShared(t *testing.T) {
+	a := assert.New(t)
+	r := require.New(t)
+
+	first, cancelFirst, err := sharedListener.subscribe()
+	r.NoError(err)
+	second, cancelSecond, err := sharedListener.subscribe()
+	r.NoError(err)
+
+	sendFrame(t, "127.0.0.2", CommandSearchForFireplaces, nil)
+
+	for _, frames := range []<-chan frame{first, second} {
+		select {
+		case f := <-frames:
+			a.Equal(CommandSearchForFireplaces, f.cmd.CommandID)
+			a.Equal("127.0.0.2", f.from.IP.String())
+		case <-time.After(time.Second):
+			t.Fatal("every subscriber should receive the frame")
+		}
+	}
+
+	cancelFirst()
+	sharedListener.mu.Lock()
+	a.NotNil(sharedListener.conn)
+	sharedListener.mu.Unlock()
+
+	cancelSecond()
+	sharedListener.mu.Lock()
+	a.Nil(sharedListener.conn, "the socket is closed after the last subscriber")
+	sharedListener.mu.Unlock()
+}
+
+func TestMonitorStatusFrame(t *testing.T) {
+	a := assert.New(t)
+
+	m := NewMonitor(NewFireplace(net.ParseIP("127.0.0.2")))
+	m.PollInterval = 0
+	m.ReadStatus = func() (*Status, error) {
+		t.Error("a status frame should not prompt a read")
+		return nil, errors.New("unexpected read")
+	}
+	m.Observe(&Status{TargetTempertaure: 20})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan ExternalChange)
+	stopped := make(chan error, 1)
+	go func() { stopped <- m.Run(ctx, events) }()
+	defer func() {
+		cancel()
+		a.NoError(<-stopped)
+	}()
+
+	// The fireplace tells another controller about a new target temperature.
+	a.Eventually(func() bool {
+		sharedListener.mu.Lock()
+		defer sharedListener.mu.Unlock()
+		return len(sharedListener.subscribers) > 0
+	}, time.Second, 5*time.Millisecond)
+	sendFrame(t, "127.0.0.2", ResponseStatus, []byte{0, 1, 0, 0, 22, 18})
+
+	select {
+	case change := <-events:
+		a.Equal(SourceRemote, change.Source)
+		a.EqualValues(22, change.Current.TargetTempertaure)
+		a.Equal(ResponseStatus, change.Frame.CommandID)
+		a.Equal("127.0.0.2", change.From.IP.String())
+	case <-time.After(time.Second):
+		t.Fatal("the change was not reported")
+	}
+}
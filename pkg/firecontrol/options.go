@@ -0,0 +1,60 @@
+package firecontrol
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// Option configures a Fireplace.
+type Option func(*Fireplace)
+
+// WithDryRun makes the fireplace write every command that would change its
+// state to w, decoded, instead of sending it. Status requests and searches are
+// still sent so callers can see the current state.
+func WithDryRun(w io.Writer) Option {
+	return func(f *Fireplace) {
+		f.dryRun = w
+	}
+}
+
+// Configure applies opts to the fireplace.
+func (f *Fireplace) Configure(opts ...Option) {
+	for _, opt := range opts {
+		opt(f)
+	}
+}
+
+// commandAcks maps each command to the response the fireplace acknowledges it
+// with.
+var commandAcks = map[CommandCode]CommandCode{
+	CommandPowerOn:        ResponsePowerOnAck,
+	CommandPowerOff:       ResponsePowerOffAck,
+	CommandFanBoostOn:     ResponseFanBoostOnAck,
+	CommandFanBoostOff:    ResponseFanBoostOffAck,
+	CommandFlameEffectOn:  ResponseFlameEffectOnAck,
+	CommandFlameEffectOff: ResponseFlameEffectOffAck,
+	CommandSetTemperature: ResponseTemperatureAck,
+}
+
+// isMutating reports whether sending c may change the fireplace's state.
+// Unknown commands are assumed to.
+func isMutating(c CommandCode) bool {
+	return c != CommandStatusPlease && c != CommandSearchForFireplaces
+}
+
+// dryRunCommand prints the packet that would have been sent and returns the
+// packet the fireplace would acknowledge it with, if known.
+func (f *Fireplace) dryRunCommand(command CommandCode, data []byte) *Command {
+	packet := marshalCommandPacket(command, data)
+	cmd, _ := UnmarshalCommandPacket(packet)
+
+	fmt.Fprintf(f.dryRun, "dry-run: %s -> %s [%s]\n", cmd, f.Addr, hex.EncodeToString(packet))
+
+	ack, ok := commandAcks[command]
+	if !ok {
+		return nil
+	}
+	reply, _ := UnmarshalCommandPacket(marshalCommandPacket(ack, nil))
+	return reply
+}
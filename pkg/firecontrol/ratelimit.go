@@ -0,0 +1,57 @@
+package firecontrol
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultCommandInterval is a gap between commands the fireplace is known to
+// keep up with.
+const DefaultCommandInterval = 500 * time.Millisecond
+
+// RateLimiter spaces out the commands sent to a fireplace, which can drop
+// commands that arrive in quick succession. It is safe for concurrent use, so
+// one limiter can be shared by everything talking to the same fireplace.
+type RateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+
+	// sleep is replaced in tests.
+	sleep func(time.Duration)
+}
+
+// NewRateLimiter returns a limiter that lets a command through at most every
+// interval.
+func NewRateLimiter(interval time.Duration) *RateLimiter {
+	return &RateLimiter{interval: interval, sleep: time.Sleep}
+}
+
+// WithRateLimit sends commands to the fireplace at most every interval, waiting
+// as needed. Monitors created for the fireplace share its limit.
+func WithRateLimit(interval time.Duration) Option {
+	return func(f *Fireplace) {
+		f.limiter = NewRateLimiter(interval)
+	}
+}
+
+// Wait blocks until the next command may be sent. Callers are let through in
+// the order they call Wait. A nil limiter never blocks.
+func (l *RateLimiter) Wait() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	if d := at.Sub(now); d > 0 {
+		l.sleep(d)
+	}
+}
@@ -0,0 +1,41 @@
+package firecontrol
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	a := assert.New(t)
+
+	l := NewRateLimiter(time.Second)
+	var slept []time.Duration
+	l.sleep = func(d time.Duration) { slept = append(slept, d) }
+
+	l.Wait()
+	l.Wait()
+	l.Wait()
+
+	// The first command goes straight away, the rest are spaced out.
+	a.Len(slept, 2)
+	a.InDelta(time.Second, slept[0], float64(100*time.Millisecond))
+	a.InDelta(2*time.Second, slept[1], float64(100*time.Millisecond))
+
+	// Once the interval has passed there is no wait.
+	l.next = time.Now().Add(-time.Millisecond)
+	l.Wait()
+	a.Len(slept, 2)
+
+	a.NotPanics(func() { (*RateLimiter)(nil).Wait() })
+}
+
+func TestWithRateLimit(t *testing.T) {
+	a := assert.New(t)
+
+	f := NewFireplace(net.ParseIP("10.0.0.40"), WithRateLimit(time.Second))
+	a.NotNil(f.limiter)
+	a.Same(f.limiter, NewMonitor(f).fireplace.limiter, "the monitor shares the fireplace's limit")
+}
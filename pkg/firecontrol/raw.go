@@ -0,0 +1,71 @@
+package firecontrol
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+)
+
+func (c *Command) String() string {
+	return fmt.Sprintf("%s size=%d data=%s crc=0x%02X",
+		c.CommandID, c.DataSize, hex.EncodeToString(c.Data[:min(c.DataSize, maxDataSize)]), c.CRC)
+}
+
+// Decode returns the payload carried by a response packet, or an error if the
+// command is not a response this package understands.
+func (c *Command) Decode() (FireplaceData, error) {
+	return handleResponse(c)
+}
+
+// SendRaw sends a single framed command to the fireplace and returns every
+// valid packet received in reply before window elapses. Invalid packets are
+// skipped. It is intended for exploring the protocol, most callers should use
+// the typed commands instead.
+func (f *Fireplace) SendRaw(command CommandCode, data []byte, window time.Duration) ([]*Command, error) {
+	packet, err := MarshalCommandPacket(command, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.dryRun != nil && isMutating(command) {
+		f.dryRunCommand(command, data)
+		return nil, nil
+	}
+
+	f.limiter.Wait()
+	conn, err := f.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write(packet)
+	if err != nil {
+		return nil, err
+	}
+	journal.record(f.Addr, command)
+
+	conn.SetReadDeadline(time.Now().Add(window))
+	conn.SetReadBuffer(readBufferSize)
+
+	replies := make([]*Command, 0)
+	for {
+		buffer := make([]byte, readBufferSize)
+		n, _, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				break
+			}
+			return replies, err
+		}
+
+		cmd, err := UnmarshalCommandPacket(buffer[:n])
+		if err != nil {
+			continue
+		}
+		replies = append(replies, cmd)
+	}
+
+	return replies, nil
+}
@@ -2,6 +2,7 @@ package firecontrol
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"time"
 )
@@ -11,12 +12,35 @@ const (
 	timeout        = 3 * time.Second
 )
 
-func (f *Fireplace) rpc(command CommandCode, data []byte) (FireplaceData, error) {
+// dial opens a UDP connection to the fireplace from the fireplace port, which
+// is where the fireplace sends its replies.
+func (f *Fireplace) dial() (*net.UDPConn, error) {
 	if f.Addr == nil {
 		return nil, errors.New("fireplace address is nil")
 	}
 
-	conn, err := net.DialUDP("udp4", &net.UDPAddr{Port: fireplacePort}, f.Addr)
+	dialer := net.Dialer{
+		LocalAddr: &net.UDPAddr{Port: fireplacePort},
+		Control:   reusePort,
+	}
+	conn, err := dialer.Dial("udp4", f.Addr.String())
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+func (f *Fireplace) rpc(command CommandCode, data []byte) (FireplaceData, error) {
+	if f.dryRun != nil && isMutating(command) {
+		reply := f.dryRunCommand(command, data)
+		if reply == nil {
+			return nil, fmt.Errorf("%w: unknown command ID: %d", ErrUnexpectedResponse, command)
+		}
+		return handleResponse(reply)
+	}
+
+	f.limiter.Wait()
+	conn, err := f.dial()
 	if err != nil {
 		return nil, err
 	}
@@ -27,6 +51,7 @@ func (f *Fireplace) rpc(command CommandCode, data []byte) (FireplaceData, error)
 	if err != nil {
 		return nil, err
 	}
+	journal.record(f.Addr, command)
 
 	conn.SetReadDeadline(time.Now().Add(timeout))
 	conn.SetReadBuffer(readBufferSize)
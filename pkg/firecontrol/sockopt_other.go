@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package firecontrol
+
+import "syscall"
+
+func reusePort(network, address string, c syscall.RawConn) error {
+	return nil
+}
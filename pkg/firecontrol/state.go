@@ -0,0 +1,172 @@
+package firecontrol
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrNotConfirmed = errors.New("fireplace did not confirm the change")
+
+const (
+	confirmAttempts = 3
+	confirmDelay    = 1 * time.Second
+)
+
+// DesiredState describes the settings a fireplace should have. Settings left
+// nil are not changed.
+type DesiredState struct {
+	Power             *bool `json:"power,omitempty" yaml:"power,omitempty"`
+	TargetTemperature *int  `json:"target_temperature,omitempty" yaml:"target_temperature,omitempty"`
+	FlameEffect       *bool `json:"flame_effect,omitempty" yaml:"flame_effect,omitempty"`
+	FanBoost          *bool `json:"fan_boost,omitempty" yaml:"fan_boost,omitempty"`
+}
+
+// Validate checks the desired settings are within the fireplace's limits.
+func (d DesiredState) Validate() error {
+	if d.TargetTemperature != nil && (*d.TargetTemperature < MinTemperature || *d.TargetTemperature > MaxTemperature) {
+		return ErrInvalidTemperature
+	}
+	return nil
+}
+
+// Step is a single command needed to move a fireplace towards a desired state.
+type Step struct {
+	Setting string `json:"setting" yaml:"setting"`
+	From    string `json:"from" yaml:"from"`
+	To      string `json:"to" yaml:"to"`
+
+	run func(f *Fireplace) error
+}
+
+// ApplyResult reports what Apply changed.
+type ApplyResult struct {
+	Before *Status
+	After  *Status
+	Steps  []Step
+}
+
+// Plan returns the commands needed to move a fireplace from current to desired,
+// in a safe order. When turning on, the target temperature is set before the
+// fire is lit so it never heats towards a stale target. When turning off, the
+// fire is put out before anything else is changed.
+func Plan(current *Status, desired DesiredState) []Step {
+	steps := make([]Step, 0)
+
+	var power *Step
+	if desired.Power != nil && *desired.Power != current.IsOn {
+		power = &Step{Setting: "power", From: onOff(current.IsOn), To: onOff(*desired.Power), run: (*Fireplace).PowerOff}
+		if *desired.Power {
+			power.run = (*Fireplace).PowerOn
+		}
+	}
+
+	if power != nil && !*desired.Power {
+		steps = append(steps, *power)
+	}
+
+	if desired.TargetTemperature != nil && *desired.TargetTemperature != int(current.TargetTempertaure) {
+		steps = append(steps, Step{
+			Setting: "target_temperature",
+			From:    fmt.Sprint(current.TargetTempertaure),
+			To:      fmt.Sprint(*desired.TargetTemperature),
+			run: func(f *Fireplace) error {
+				return f.SetTemperature(*desired.TargetTemperature)
+			},
+		})
+	}
+
+	if power != nil && *desired.Power {
+		steps = append(steps, *power)
+	}
+
+	if desired.FlameEffect != nil && *desired.FlameEffect != current.FlameEffectIsOn {
+		on := *desired.FlameEffect
+		steps = append(steps, Step{
+			Setting: "flame_effect",
+			From:    onOff(current.FlameEffectIsOn),
+			To:      onOff(on),
+			run:     func(f *Fireplace) error { return f.SetFlameEffect(on) },
+		})
+	}
+
+	if desired.FanBoost != nil && *desired.FanBoost != current.FanBoostIsOn {
+		on := *desired.FanBoost
+		steps = append(steps, Step{
+			Setting: "fan_boost",
+			From:    onOff(current.FanBoostIsOn),
+			To:      onOff(on),
+			run:     func(f *Fireplace) error { return f.SetFanBoost(on) },
+		})
+	}
+
+	return steps
+}
+
+// Apply reads the fireplace's status, sends only the commands needed to reach
+// desired and then confirms the fireplace reports the desired state.
+func (f *Fireplace) Apply(desired DesiredState) (*ApplyResult, error) {
+	if err := desired.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := f.Refresh(); err != nil {
+		return nil, err
+	}
+
+	result := &ApplyResult{Before: f.Status, Steps: Plan(f.Status, desired)}
+	for _, step := range result.Steps {
+		if err := step.run(f); err != nil {
+			return result, fmt.Errorf("setting %s to %s: %w", step.Setting, step.To, err)
+		}
+	}
+
+	if f.dryRun != nil {
+		result.After = predictStatus(f.Status, desired)
+		return result, nil
+	}
+
+	if len(result.Steps) == 0 {
+		result.After = f.Status
+		return result, nil
+	}
+
+	for attempt := 0; attempt < confirmAttempts; attempt++ {
+		time.Sleep(confirmDelay)
+		if err := f.Refresh(); err != nil {
+			continue
+		}
+		result.After = f.Status
+		if len(Plan(f.Status, desired)) == 0 {
+			return result, nil
+		}
+	}
+
+	return result, ErrNotConfirmed
+}
+
+// predictStatus returns the status a fireplace would report after reaching
+// desired.
+func predictStatus(current *Status, desired DesiredState) *Status {
+	status := *current
+	if desired.Power != nil {
+		status.IsOn = *desired.Power
+	}
+	if desired.TargetTemperature != nil {
+		status.TargetTempertaure = uint8(*desired.TargetTemperature)
+	}
+	if desired.FlameEffect != nil {
+		status.FlameEffectIsOn = *desired.FlameEffect
+	}
+	if desired.FanBoost != nil {
+		status.FanBoostIsOn = *desired.FanBoost
+	}
+	return &status
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,48 @@
+package firecontrol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func settings(steps []Step) []string {
+	names := make([]string, 0, len(steps))
+	for _, step := range steps {
+		names = append(names, step.Setting+"="+step.To)
+	}
+	return names
+}
+
+func TestPlan(t *testing.T) {
+	a := assert.New(t)
+
+	on, off := true, false
+	temp := 23
+
+	off20 := &Status{TargetTempertaure: 20}
+	on23 := &Status{IsOn: true, TargetTempertaure: 23, FlameEffectIsOn: true}
+
+	a.Equal(
+		[]string{"target_temperature=23", "power=on", "flame_effect=on"},
+		settings(Plan(off20, DesiredState{Power: &on, TargetTemperature: &temp, FlameEffect: &on})),
+	)
+	a.Equal(
+		[]string{"power=off", "target_temperature=23"},
+		settings(Plan(&Status{IsOn: true, TargetTempertaure: 20}, DesiredState{Power: &off, TargetTemperature: &temp})),
+	)
+	a.Equal(
+		[]string{"power=off", "fan_boost=off"},
+		settings(Plan(&Status{IsOn: true, FanBoostIsOn: true}, DesiredState{Power: &off, FanBoost: &off})),
+	)
+	a.Empty(Plan(on23, DesiredState{Power: &on, TargetTemperature: &temp, FlameEffect: &on}))
+}
+
+func TestDesiredStateValidate(t *testing.T) {
+	a := assert.New(t)
+
+	hot, ok := 40, 22
+	a.ErrorIs(DesiredState{TargetTemperature: &hot}.Validate(), ErrInvalidTemperature)
+	a.NoError(DesiredState{TargetTemperature: &ok}.Validate())
+	a.NoError(DesiredState{}.Validate())
+}
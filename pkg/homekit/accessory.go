@@ -3,342 +3,353 @@ package homekit
 import (
 	"context"
 	"fmt"
-	syslog "log"
-	"log/slog"
-	"os"
-	"os/signal"
-	"syscall"
+	"sort"
 	"time"
 
 	"github.com/brutella/hap"
 	"github.com/brutella/hap/accessory"
 	"github.com/brutella/hap/characteristic"
-	"github.com/brutella/hap/log"
+	"github.com/brutella/hap/service"
 	slogctx "github.com/veqryn/slog-context"
 
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/config"
 	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
 	"github.com/pkg/errors"
-	"github.com/sourcegraph/conc/pool"
-	"github.com/urfave/cli/v2"
 )
 
-type (
-	FireplaceController struct {
-		fireplace           *firecontrol.Fireplace
-		accessory           *accessory.Thermostat
-		debugLoggingEnabled bool
-		queue               chan Envelope
-	}
+const (
+	// bridgeName is the name of the bridge exposing several fireplaces.
+	bridgeName = "FireControl"
 
-	Instruction interface {
-		// Execute(ctx context.Context, fireplace *firecontrol.Fireplace) error
-		isInstruction()
-	}
+	// defaultStorageDir is where pairings are kept unless configured otherwise.
+	defaultStorageDir = "./db"
+)
 
-	internalInstruction struct {
-		responseChan chan error
-	}
+// refreshInterval is used when no refresh interval is given.
+const refreshInterval = 30 * time.Second
 
-	SetTemperatureInstruction struct {
-		*internalInstruction
-		Temperature int
-	}
+// presetSwitchResetDelay is how long a preset switch stays on after the preset
+// was applied, so it behaves like a button in the Home app.
+const presetSwitchResetDelay = 1 * time.Second
 
-	SetPowerInstruction struct {
-		*internalInstruction
-		Power bool
-	}
+// logContext returns ctx with the fireplace's address and serial added to its
+// log attributes.
+func (fc *FireplaceController) logContext(ctx context.Context) context.Context {
+	return slogctx.Append(ctx, "name", fc.name, "ip", fc.ip, "serial", fc.serial)
+}
 
-	Envelope struct {
-		Instruction  Instruction
-		responseChan chan error
+func (fc *FireplaceController) createAccessory(ctx context.Context) error {
+	info := accessory.Info{
+		Name:         fc.name,
+		SerialNumber: fmt.Sprintf("FP-%d", fc.serial),
+		Manufacturer: "Escea",
 	}
-)
-
-func (i SetTemperatureInstruction) isInstruction() {}
-func (i SetPowerInstruction) isInstruction()       {}
 
-func NewMessageEnvelope(instruction Instruction) Envelope {
-	return Envelope{Instruction: instruction, responseChan: make(chan error, 1)}
-}
-func (i Envelope) Complete(err error) {
-	if i.responseChan == nil {
-		return
+	var primary *service.S
+	switch fc.mode {
+	case config.HomeKitModeHeaterCooler:
+		fc.accessory = accessory.New(info, accessory.TypeHeater)
+		fc.heaterCooler = fc.newHeaterCooler(ctx)
+		primary = fc.heaterCooler.S
+	default:
+		fc.accessory = accessory.New(info, accessory.TypeThermostat)
+		fc.thermostat = fc.newThermostat(ctx)
+		primary = fc.thermostat.S
 	}
-	i.responseChan <- err
-}
+	acc := fc.accessory
+	acc.AddS(primary)
 
-func NewTemperatureInstruction(temp int) Instruction {
-	return SetTemperatureInstruction{
-		internalInstruction: &internalInstruction{responseChan: make(chan error, 1)},
-		Temperature:         temp,
+	if fc.settings.Has(config.CapabilityFlameEffect) {
+		fc.flameEffect = fc.settingSwitch(ctx, "Flame Effect", NewFlameEffectInstruction)
+		acc.AddS(fc.flameEffect.S)
 	}
-}
-
-func NewPowerInstruction(power bool) Instruction {
-	return SetPowerInstruction{
-		internalInstruction: &internalInstruction{responseChan: make(chan error, 1)},
-		Power:               power,
+	// A heater cooler shows fan boost as its rotation speed instead.
+	if fc.settings.Has(config.CapabilityFanBoost) && fc.heaterCooler == nil {
+		fc.fanBoost = fc.settingSwitch(ctx, "Fan Boost", NewFanBoostInstruction)
+		acc.AddS(fc.fanBoost.S)
 	}
-}
 
-const refreshInterval = 30 * time.Second
-
-func AccessoryAction(c *cli.Context) error {
-	ctx := c.Context
-
-	h := slogctx.NewHandler(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}), nil)
+	if fc.exposeTemperatureSensor {
+		fc.temperatureSensor = service.NewTemperatureSensor()
+		n := characteristic.NewName()
+		n.SetValue("Room Temperature")
+		fc.temperatureSensor.AddC(n.C)
+		acc.AddS(fc.temperatureSensor.S)
+	}
 
-	slog.SetDefault(slog.New(h))
-	slog.SetLogLoggerLevel(slog.LevelDebug)
+	for _, name := range sortedPresetNames(fc.presets) {
+		acc.AddS(fc.presetSwitch(ctx, name, fc.presets[name]).S)
+	}
 
-	slog.Debug("Starting HomeKit accessory with debug logging enabled", "debug", c.Bool("debug"))
+	fc.statusFault = characteristic.NewStatusFault()
+	primary.AddC(fc.statusFault.C)
+	fc.guardReads(acc)
 
-	slog.Info("Starting HomeKit accessory")
-	fireplaces, err := firecontrol.SearchForFireplaces()
-	if err != nil {
-		return errors.Wrap(err, "searching for fireplaces")
+	// Added after guarding reads so the Eve app can read the history while
+	// the fireplace is not answering.
+	if fc.history != nil {
+		acc.AddS(fc.history.S)
 	}
 
-	slogctx.Info(ctx, "Completed fireplace search", "found-count", len(fireplaces))
-
-	pin := c.Int("pin")
-	serial := c.Int("serial")
-
-	// Setup a listener for interrupts and SIGTERM signals
-	// to stop the server.
-	sigChan := make(chan os.Signal)
-	signal.Notify(sigChan, os.Interrupt)
-	signal.Notify(sigChan, syscall.SIGTERM)
-	ctx, cancel := context.WithCancel(ctx)
-	go func() {
-		<-sigChan
-		slog.Info("Interrupt signal received")
-		// Stop delivering signals.
-		signal.Stop(sigChan)
-		// Cancel the context to stop the server.
-		cancel()
-	}()
-
-	p := pool.New().WithErrors().WithContext(ctx)
-
-	for _, fireplace := range fireplaces {
-		if fireplace.Serial != uint32(serial) || fireplace.PIN != uint16(pin) {
-			slog.Info("Skipping fireplace", "serial", fireplace.Serial)
-			continue
-		}
+	return nil
+}
 
-		ctx = slogctx.Append(ctx, "ip", fireplace.Addr.IP.String(), "serial", fireplace.Serial)
-		slog.InfoContext(ctx, "Starting Controller")
+// newThermostat returns the thermostat service controlling the fireplace.
+func (fc *FireplaceController) newThermostat(ctx context.Context) *service.Thermostat {
+	th := service.NewThermostat()
 
-		controller := &FireplaceController{
-			fireplace:           fireplace,
-			debugLoggingEnabled: c.Bool("debug"),
-			queue:               make(chan Envelope, 10),
-		}
+	// Configure display units to be in Celsius
+	th.TemperatureDisplayUnits.SetValue(characteristic.TemperatureDisplayUnitsCelsius)
 
-		// Start the controller in a new goroutine
-		p.Go(controller.Start)
-	}
+	// target := characteristic.NewTargetTemperature()
+	th.TargetTemperature.SetStepValue(1)
+	th.TargetTemperature.SetMaxValue(30)
+	// th.TargetTemperature.SetMinValue(16)
+	// th.TargetTemperature.SetValue(22)
 
-	return p.Wait()
-}
+	onRemoteWrite(th.TargetTemperature.C, func(v float64) error {
+		return fc.setTemperatureFromHomeKit(ctx, v)
+	})
 
-func (fc *FireplaceController) Start(ctx context.Context) error {
-	slog.InfoContext(ctx, "Starting fireplace controller")
+	th.TargetHeatingCoolingState.ValidVals = []int{characteristic.TargetHeatingCoolingStateHeat, characteristic.TargetHeatingCoolingStateOff}
+	onRemoteWrite(th.TargetHeatingCoolingState.C, func(targetState int) error {
+		on := targetState == characteristic.TargetHeatingCoolingStateHeat
+		fc.logger.InfoContext(ctx, "Target heating cooling state set", "state", targetState, "power", on)
+		return fc.setPowerFromHomeKit(ctx, on)
+	})
 
-	ticker := time.NewTicker(refreshInterval)
-	defer ticker.Stop()
+	return th
+}
 
-	err := fc.createAccessory(ctx)
-	if err != nil {
-		return errors.Wrap(err, "creating accessory")
+// setTemperatureFromHomeKit sets the target temperature to v, written from
+// HomeKit. The write is answered as soon as the temperature is queued: a
+// HomeKit controller waits for each write before sending the next, so waiting
+// for the fireplace would leave nothing to coalesce while the slider is dragged.
+// If the fireplace then fails to take the temperature, the refresh that follows
+// puts its own back in HomeKit.
+func (fc *FireplaceController) setTemperatureFromHomeKit(ctx context.Context, v float64) error {
+	fc.logger.InfoContext(ctx, "Target Temperature Set", "value", v)
+
+	temp := int(v)
+	if temp < firecontrol.MinTemperature || temp > firecontrol.MaxTemperature {
+		return firecontrol.ErrInvalidTemperature
 	}
 
-	err = fc.refreshStatus(ctx)
+	err := fc.post(NewTemperatureInstruction(temp))
 	if err != nil {
-		slog.ErrorContext(ctx, "Failed to refresh fireplace", "error", err)
+		fc.logger.ErrorContext(ctx, "Failed to set target temperature", "error", err, "temperature", v)
+		return errors.Wrap(err, "setting target temperature")
 	}
+	return nil
+}
 
-	go func() {
-		slog.InfoContext(ctx, "Starting fireplace controller refresh loop")
-		for {
-			select {
-			case <-ticker.C:
-				err := fc.refreshStatus(ctx)
-				if err != nil {
-					slog.ErrorContext(ctx, "Failed to refresh fireplace", "error", err)
-					continue
-				}
-
-				slog.InfoContext(ctx, "Refreshed fireplace",
-					"room-temperature", fc.fireplace.Status.CurrentTemperature,
-					"target-temperature", fc.fireplace.Status.TargetTempertaure,
-					"status", fireplaceStatusString(fc.fireplace.Status),
-				)
-			case <-ctx.Done():
-				close(fc.queue)
-				slog.InfoContext(ctx, "Stopping fireplace controller")
-				return
-
-			case msg := <-fc.queue:
-				slog.Debug("Received instruction", "instruction", msg.Instruction)
-				switch i := msg.Instruction.(type) {
-				case SetTemperatureInstruction:
-					msg.Complete(fc.setTargetTemperature(ctx, float64(i.Temperature)))
-				case SetPowerInstruction:
-					if i.Power {
-						msg.Complete(fc.fireplace.PowerOn())
-					} else {
-						msg.Complete(fc.fireplace.PowerOff())
-					}
-				}
-			default:
-				// To avoid busy waiting
-				time.Sleep(500 * time.Millisecond)
-			}
-		}
-	}()
-
-	err = fc.startServer(ctx)
+// setPowerFromHomeKit turns the fireplace on or off, written from HomeKit.
+func (fc *FireplaceController) setPowerFromHomeKit(ctx context.Context, on bool) error {
+	err := fc.send(NewPowerInstruction(on))
 	if err != nil {
-		return errors.Wrap(err, "starting server")
+		fc.logger.ErrorContext(ctx, "Failed to set power state", "error", err, "power", on)
+		return errors.Wrap(err, "setting power state")
 	}
-
+	fc.logger.InfoContext(ctx, "Successfully set power state", "power", on)
 	return nil
 }
 
-func (fc *FireplaceController) createAccessory(ctx context.Context) error {
-	acc := accessory.NewThermostat(accessory.Info{
-		Name:         "Fireplace",
-		SerialNumber: fmt.Sprintf("FP-%d", fc.fireplace.Serial),
-		Manufacturer: "Escea",
-	})
-
-	// Configure display units to be in Celsius
-	acc.Thermostat.TemperatureDisplayUnits.SetValue(characteristic.TemperatureDisplayUnitsCelsius)
-
-	// target := characteristic.NewTargetTemperature()
-	acc.Thermostat.TargetTemperature.SetStepValue(1)
-	acc.Thermostat.TargetTemperature.SetMaxValue(30)
-	// acc.Thermostat.TargetTemperature.SetMinValue(16)
-	// acc.Thermostat.TargetTemperature.SetValue(22)
+// settingSwitch returns a switch named name that sends the instruction made by
+// instruction when toggled from HomeKit.
+func (fc *FireplaceController) settingSwitch(ctx context.Context, name string, instruction func(on bool) Instruction) *service.Switch {
+	sw := service.NewSwitch()
 
-	acc.Thermostat.TargetTemperature.OnSetRemoteValue(func(v float64) error {
-		slog.InfoContext(ctx, "Target Temperature Set", "value", v)
+	n := characteristic.NewName()
+	n.SetValue(name)
+	sw.AddC(n.C)
 
-		msg := NewMessageEnvelope(NewTemperatureInstruction(int(v)))
-		fc.queue <- msg
+	onRemoteWrite(sw.On.C, func(on bool) error {
+		fc.logger.InfoContext(ctx, "Switch set", "switch", name, "on", on)
 
-		err := <-msg.responseChan
+		err := fc.send(instruction(on))
 		if err != nil {
-			slog.ErrorContext(ctx, "Failed to set target temperature", "error", err, "temperature", v)
-			return errors.Wrap(err, "setting target temperature")
+			fc.logger.ErrorContext(ctx, "Failed to set switch", "error", err, "switch", name, "on", on)
+			return errors.Wrapf(err, "setting %s", name)
 		}
-		slog.InfoContext(ctx, "Successfully set target temperature", "temperature", v)
 		return nil
 	})
 
-	acc.Thermostat.TargetHeatingCoolingState.ValidVals = []int{characteristic.TargetHeatingCoolingStateHeat, characteristic.TargetHeatingCoolingStateOff}
-	acc.Thermostat.TargetHeatingCoolingState.OnSetRemoteValue(func(targetState int) error {
-		switch targetState {
-		case characteristic.TargetHeatingCoolingStateAuto:
-			slog.InfoContext(ctx, "TargetHeatingCoolingState: Auto")
-		case characteristic.TargetHeatingCoolingStateHeat:
-			slog.InfoContext(ctx, "TargetHeatingCoolingState: Heat")
-
-			msg := NewMessageEnvelope(NewPowerInstruction(true))
-			fc.queue <- msg
-
-			err := <-msg.responseChan
-			if err != nil {
-				slog.ErrorContext(ctx, "Failed to set power state to on", "error", err, "temperature", targetState)
-				return errors.Wrap(err, "set power state")
-			}
-			slog.InfoContext(ctx, "Successfully set power state to on", "temperature", targetState)
+	return sw
+}
+
+// presetSwitch returns a switch that applies preset when turned on, then turns
+// itself off again.
+func (fc *FireplaceController) presetSwitch(ctx context.Context, name string, preset config.Preset) *service.Switch {
+	sw := service.NewSwitch()
+
+	n := characteristic.NewName()
+	n.SetValue(name)
+	sw.AddC(n.C)
+
+	onRemoteWrite(sw.On.C, func(on bool) error {
+		if !on {
 			return nil
-		case characteristic.TargetHeatingCoolingStateOff:
-			slog.InfoContext(ctx, "TargetHeatingCoolingState: Off")
+		}
 
-			// Turn off the fireplace
-			err := fc.fireplace.PowerOff()
-			if err != nil {
-				return errors.Wrap(err, "turning off fireplace")
-			}
+		fc.logger.InfoContext(ctx, "Applying preset", "preset", name)
 
-			time.Sleep(1 * time.Second)
+		err := fc.send(NewApplyPresetInstruction(name, preset))
+		if err != nil {
+			fc.logger.ErrorContext(ctx, "Failed to apply preset", "error", err, "preset", name)
+			return errors.Wrap(err, "applying preset")
 		}
 
+		go func() {
+			time.Sleep(presetSwitchResetDelay)
+			sw.On.SetValue(false)
+		}()
 		return nil
 	})
 
-	fc.accessory = acc
-	return nil
+	return sw
 }
 
-func (fc *FireplaceController) refreshStatus(_ context.Context) error {
-	// slog.InfoContext(ctx, "Refreshing fireplace status")
+func sortedPresetNames(presets map[string]config.Preset) []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
 
-	err := fc.fireplace.Refresh()
-	if err != nil {
-		return errors.Wrap(err, "refreshing fireplace")
+// updateCharacteristics pushes status to the accessory's characteristics,
+// notifying any connected HomeKit controllers of changed values.
+func (fc *FireplaceController) updateCharacteristics(status *firecontrol.Status) error {
+	if fc.temperatureSensor != nil {
+		fc.temperatureSensor.CurrentTemperature.SetValue(float64(status.CurrentTemperature))
+	}
+	if fc.flameEffect != nil {
+		fc.flameEffect.On.SetValue(status.FlameEffectIsOn)
+	}
+	if fc.fanBoost != nil {
+		fc.fanBoost.On.SetValue(status.FanBoostIsOn)
 	}
 
-	acc := fc.accessory
-	th := acc.Thermostat
-	th.CurrentTemperature.SetValue(float64(fc.fireplace.Status.CurrentTemperature))
+	fc.heating = isHeating(status, fc.heating, fc.hysteresis)
+
+	if fc.heaterCooler != nil {
+		return fc.updateHeaterCooler(status)
+	}
+	return fc.updateThermostat(status)
+}
 
-	if fc.fireplace.Status.IsOn {
-		th.TargetTemperature.SetValue(float64(fc.fireplace.Status.TargetTempertaure))
-		err = th.TargetHeatingCoolingState.SetValue(characteristic.TargetHeatingCoolingStateHeat)
+func (fc *FireplaceController) updateThermostat(status *firecontrol.Status) error {
+	th := fc.thermostat
+	th.CurrentTemperature.SetValue(float64(status.CurrentTemperature))
+
+	if status.IsOn {
+		th.TargetTemperature.SetValue(float64(status.TargetTempertaure))
+		err := th.TargetHeatingCoolingState.SetValue(characteristic.TargetHeatingCoolingStateHeat)
 		if err != nil {
 			return errors.Wrap(err, "setting target heating cooling state")
 		}
-		err = th.CurrentHeatingCoolingState.SetValue(characteristic.CurrentHeatingCoolingStateHeat)
-		if err != nil {
-			return errors.Wrap(err, "setting current heating cooling state")
-		}
 	} else {
-		err = th.TargetHeatingCoolingState.SetValue(characteristic.TargetHeatingCoolingStateOff)
+		err := th.TargetHeatingCoolingState.SetValue(characteristic.TargetHeatingCoolingStateOff)
 		if err != nil {
 			return errors.Wrap(err, "setting target heating cooling state")
 		}
-		err = th.CurrentHeatingCoolingState.SetValue(characteristic.CurrentHeatingCoolingStateOff)
-		if err != nil {
-			return errors.Wrap(err, "setting current heating cooling state")
-		}
 	}
 
-	return nil
-}
-
-func (fc *FireplaceController) setTargetTemperature(ctx context.Context, temp float64) error {
-	slog.InfoContext(ctx, "Setting target temperature", "temperature", temp)
-	err := fc.fireplace.SetTemperature(int(temp))
+	// The Home app shows Heating or Idle from the current state, Off means idle.
+	current := characteristic.CurrentHeatingCoolingStateOff
+	if fc.heating {
+		current = characteristic.CurrentHeatingCoolingStateHeat
+	}
+	err := th.CurrentHeatingCoolingState.SetValue(current)
 	if err != nil {
-		return errors.Wrap(err, "setting target temperature")
+		return errors.Wrap(err, "setting current heating cooling state")
 	}
 
 	return nil
 }
 
-func (fc *FireplaceController) startServer(ctx context.Context) error {
-	slog.Info("Starting HomeKit server")
+// isHeating estimates whether the fireplace is burning rather than idling. The
+// fireplace does not report this, so like a thermostat it is assumed to heat
+// until the room reaches the target, then idle until the room falls hysteresis
+// degrees below it. In between it keeps doing what it was doing.
+func isHeating(status *firecontrol.Status, wasHeating bool, hysteresis int) bool {
+	room, target := int(status.CurrentTemperature), int(status.TargetTempertaure)
+	switch {
+	case !status.IsOn, room >= target:
+		return false
+	case room <= target-hysteresis:
+		return true
+	}
+	return wasHeating
+}
 
-	fs := hap.NewFsStore("./db")
+// newServer returns a HomeKit server for the controllers' accessories. With
+// settings.Bridge set, or more than one controller, the accessories are exposed
+// behind a single bridge so they are all added to the Home app with one pairing.
+func newServer(fs hap.Store, controllers []*FireplaceController, settings config.HomeKit) (*hap.Server, error) {
+	var (
+		server *hap.Server
+		err    error
+	)
+	if settings.Bridge || len(controllers) > 1 {
+		name := settings.Name
+		if name == "" {
+			name = bridgeName
+		}
+		b := accessory.NewBridge(accessory.Info{
+			Name:         name,
+			Manufacturer: "Escea",
+		})
+
+		accessories := make([]*accessory.A, 0, len(controllers))
+		for _, fc := range controllers {
+			fc.accessory.Id = accessoryID(fc.serial)
+			accessories = append(accessories, fc.accessory)
+		}
+		server, err = hap.NewServer(fs, b.A, accessories...)
+	} else {
+		// A standalone accessory must have ID 1, which the server gives it.
+		server, err = hap.NewServer(fs, controllers[0].accessory)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	newLogger := syslog.New(os.Stdout, "SERV ", syslog.LstdFlags|syslog.Lshortfile)
-	log.Debug = &log.Logger{newLogger}
+	code := settings.SetupCode
+	if code != "" {
+		code, err = normalizeSetupCode(code)
+	} else {
+		code, err = persistedSetupCode(fs)
+	}
+	if err != nil {
+		return nil, err
+	}
+	server.Pin = code
 
-	// Create the hap server.
-	server, err := hap.NewServer(fs, fc.accessory.A)
+	server.SetupId, err = persistedSetupID(fs)
 	if err != nil {
-		// stop if an error happens
-		return errors.Wrap(err, "creating server")
+		return nil, err
 	}
 
-	// Run the server.
-	return server.ListenAndServe(ctx)
+	server.Addr, err = listenAddr(settings.Interface, settings.Port)
+	if err != nil {
+		return nil, err
+	}
+	if settings.Interface != "" {
+		server.Ifaces = []string{settings.Interface}
+	}
+
+	return server, nil
+}
+
+// accessoryID returns the accessory ID for the bridged fireplace with the given
+// serial, so each fireplace keeps its room and automations in the Home app
+// however many fireplaces are found or the order they are found in. IDs are
+// offset by one as 1 is reserved for the bridge. Bridge.Run only accepts
+// fireplaces with distinct, non-zero serials.
+func accessoryID(serial uint32) uint64 {
+	return uint64(serial) + 1
 }
 
 func fireplaceStatusString(status *firecontrol.Status) string {
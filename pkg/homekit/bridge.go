@@ -0,0 +1,259 @@
+package homekit
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/brutella/hap"
+	"github.com/brutella/hap/accessory"
+	"github.com/pkg/errors"
+	"github.com/sourcegraph/conc/pool"
+	slogctx "github.com/veqryn/slog-context"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/config"
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
+)
+
+type (
+	// Bridge exposes fireplaces to HomeKit, behind a HomeKit bridge when there
+	// is more than one.
+	Bridge struct {
+		fireplaces      []bridgedFireplace
+		settings        config.HomeKit
+		store           hap.Store
+		logger          *slog.Logger
+		refreshInterval time.Duration
+		faultThreshold  int
+		presets         map[string]config.Preset
+		setupOutput     io.Writer
+	}
+
+	bridgedFireplace struct {
+		name      string
+		fireplace *firecontrol.Fireplace
+		settings  config.Fireplace
+	}
+
+	// BridgeOption configures a Bridge.
+	BridgeOption func(*Bridge)
+)
+
+// WithFireplace exposes fp under name. settings lists the capabilities it
+// supports, every capability is assumed if it lists none. The fireplace is
+// identified before it is used and shown as not responding until it answers.
+func WithFireplace(name string, fp *firecontrol.Fireplace, settings config.Fireplace) BridgeOption {
+	return func(b *Bridge) {
+		b.fireplaces = append(b.fireplaces, bridgedFireplace{name: name, fireplace: fp, settings: settings})
+	}
+}
+
+// WithHomeKit applies the HomeKit settings from the configuration file, such
+// as the setup code and where pairings are kept.
+func WithHomeKit(settings config.HomeKit) BridgeOption {
+	return func(b *Bridge) {
+		b.settings = settings
+	}
+}
+
+// WithStore keeps pairings and history in st rather than in the storage
+// directory. The store is not locked against other processes.
+func WithStore(st hap.Store) BridgeOption {
+	return func(b *Bridge) {
+		b.store = st
+	}
+}
+
+// WithLogger logs to logger rather than the default logger.
+func WithLogger(logger *slog.Logger) BridgeOption {
+	return func(b *Bridge) {
+		b.logger = logger
+	}
+}
+
+// WithRefreshInterval sets how often the fireplaces' status is read.
+func WithRefreshInterval(d time.Duration) BridgeOption {
+	return func(b *Bridge) {
+		b.refreshInterval = d
+	}
+}
+
+// WithFaultThreshold sets how many refreshes in a row must fail before HomeKit
+// is told a fireplace is not responding.
+func WithFaultThreshold(n int) BridgeOption {
+	return func(b *Bridge) {
+		b.faultThreshold = n
+	}
+}
+
+// WithPresets exposes each preset as a switch on every fireplace.
+func WithPresets(presets map[string]config.Preset) BridgeOption {
+	return func(b *Bridge) {
+		b.presets = presets
+	}
+}
+
+// WithSetupOutput writes a QR code and setup code for pairing to w while the
+// bridge is not paired.
+func WithSetupOutput(w io.Writer) BridgeOption {
+	return func(b *Bridge) {
+		b.setupOutput = w
+	}
+}
+
+// NewBridge returns a bridge configured by opts.
+func NewBridge(opts ...BridgeOption) *Bridge {
+	b := &Bridge{
+		logger:          slog.Default(),
+		refreshInterval: refreshInterval,
+		faultThreshold:  defaultFaultThreshold,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	// Add the fireplace's name, address and serial from the context to log
+	// records, see FireplaceController.logContext.
+	b.logger = slog.New(slogctx.NewHandler(b.logger.Handler(), nil))
+	return b
+}
+
+// Run serves HomeKit until ctx is cancelled, returning nil once it has shut
+// down cleanly. A bridge can only be run once.
+func (b *Bridge) Run(ctx context.Context) error {
+	if len(b.fireplaces) == 0 {
+		return errors.New("no fireplaces to expose to HomeKit")
+	}
+	if !config.ValidHomeKitMode(b.settings.Mode) {
+		return errors.Errorf("unknown HomeKit mode %q, expected %s or %s", b.settings.Mode, config.HomeKitModeThermostat, config.HomeKitModeHeaterCooler)
+	}
+	if err := b.checkSerials(); err != nil {
+		return err
+	}
+
+	store := b.store
+	if store == nil {
+		dir := b.settings.StorageDir
+		if dir == "" {
+			dir = defaultStorageDir
+		}
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return errors.Wrap(err, "creating storage directory")
+		}
+
+		// Hold the store while serving, so pairing commands and other
+		// instances leave it alone.
+		lock, err := lockStore(dir)
+		if err != nil {
+			return err
+		}
+		defer lock.Close()
+
+		store = hap.NewFsStore(dir)
+	}
+
+	controllers, err := b.controllers(ctx, store)
+	if err != nil {
+		return err
+	}
+
+	server, err := newServer(store, controllers, b.settings)
+	if err != nil {
+		return errors.Wrap(err, "creating server")
+	}
+
+	if !server.IsPaired() && b.setupOutput != nil {
+		name := controllers[0].name
+		category := byte(controllers[0].accessory.Type)
+		if b.bridged() {
+			name, category = b.name(), accessory.TypeBridge
+		}
+		printSetup(b.setupOutput, name, category, server.Pin, server.SetupId)
+	}
+
+	p := pool.New().WithErrors().WithContext(ctx)
+	for _, controller := range controllers {
+		controller := controller
+		p.Go(func(ctx context.Context) error {
+			return controller.Start(controller.logContext(ctx))
+		})
+	}
+	p.Go(func(ctx context.Context) error {
+		b.logger.InfoContext(ctx, "Starting HomeKit server")
+		err := server.ListenAndServe(ctx)
+		if stderrors.Is(err, http.ErrServerClosed) {
+			// The server closes when ctx is cancelled, which is a clean shutdown.
+			return nil
+		}
+		return err
+	})
+
+	return p.Wait()
+}
+
+// controllers returns a controller with its accessory for each fireplace.
+func (b *Bridge) controllers(ctx context.Context, store hap.Store) ([]*FireplaceController, error) {
+	controllers := make([]*FireplaceController, 0, len(b.fireplaces))
+	for _, f := range b.fireplaces {
+		name := f.name
+		if !b.bridged() && b.settings.Name != "" {
+			name = b.settings.Name
+		}
+
+		controller := NewFireplaceController(name, f.fireplace, f.settings, b.presets, b.refreshInterval)
+		controller.logger = b.logger
+		controller.faultThreshold = b.faultThreshold
+		if b.settings.HeatingHysteresis > 0 {
+			controller.hysteresis = b.settings.HeatingHysteresis
+		}
+		controller.exposeTemperatureSensor = b.settings.TemperatureSensor
+		controller.mode = b.settings.Mode
+
+		var err error
+		controller.history, err = newHistory(store, fmt.Sprintf("%s%d", historyKeyPrefix, f.fireplace.Serial))
+		if err != nil {
+			return nil, errors.Wrapf(err, "loading history for %s", name)
+		}
+
+		if err := controller.createAccessory(controller.logContext(ctx)); err != nil {
+			return nil, errors.Wrapf(err, "creating accessory for %s", name)
+		}
+		controllers = append(controllers, controller)
+	}
+	return controllers, nil
+}
+
+// checkSerials makes sure every fireplace has its own serial, which its
+// accessory ID and history are kept under. A fireplace given only by its
+// address would otherwise share them with any other until it answers.
+func (b *Bridge) checkSerials() error {
+	names := make(map[uint32]string, len(b.fireplaces))
+	for _, f := range b.fireplaces {
+		serial := f.fireplace.Serial
+		if serial == 0 {
+			return errors.Errorf("fireplace %s has no serial", f.name)
+		}
+		if other, ok := names[serial]; ok {
+			return errors.Errorf("fireplaces %s and %s have the same serial %d", other, f.name, serial)
+		}
+		names[serial] = f.name
+	}
+	return nil
+}
+
+// bridged reports whether the fireplaces are exposed behind a HomeKit bridge.
+func (b *Bridge) bridged() bool {
+	return b.settings.Bridge || len(b.fireplaces) > 1
+}
+
+// name returns the name of the HomeKit bridge.
+func (b *Bridge) name() string {
+	if b.settings.Name != "" {
+		return b.settings.Name
+	}
+	return bridgeName
+}
@@ -0,0 +1,105 @@
+package homekit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/brutella/hap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	slogctx "github.com/veqryn/slog-context"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/config"
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
+)
+
+func TestNewBridge(t *testing.T) {
+	a := assert.New(t)
+
+	b := NewBridge()
+	a.Equal(refreshInterval, b.refreshInterval)
+	a.Equal(defaultFaultThreshold, b.faultThreshold)
+	a.False(b.bridged())
+	a.Equal(bridgeName, b.name())
+
+	var logs bytes.Buffer
+	fp := &firecontrol.Fireplace{Serial: 1}
+	b = NewBridge(
+		WithFireplace("lounge", fp, config.Fireplace{}),
+		WithFireplace("den", fp, config.Fireplace{}),
+		WithHomeKit(config.HomeKit{Name: "Home"}),
+		WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+		WithRefreshInterval(time.Minute),
+		WithFaultThreshold(5),
+	)
+	a.Len(b.fireplaces, 2)
+	a.True(b.bridged(), "several fireplaces are always bridged")
+	a.Equal("Home", b.name())
+	a.Equal(time.Minute, b.refreshInterval)
+	a.Equal(5, b.faultThreshold)
+
+	// Log records carry the attributes added to the context.
+	b.logger.InfoContext(slogctx.Append(context.Background(), "name", "lounge"), "hello")
+	a.Contains(logs.String(), "name=lounge")
+}
+
+func TestBridgeRunWithoutFireplaces(t *testing.T) {
+	assert.EqualError(t, NewBridge().Run(context.Background()), "no fireplaces to expose to HomeKit")
+}
+
+func TestNewServerAccessoryIDs(t *testing.T) {
+	a := assert.New(t)
+	r := require.New(t)
+
+	newControllers := func(serials ...uint32) []*FireplaceController {
+		var controllers []*FireplaceController
+		for _, serial := range serials {
+			fc := newController(fmt.Sprint(serial), &fakeFireplace{}, config.Fireplace{}, nil, time.Hour)
+			fc.serial = serial
+			r.NoError(fc.createAccessory(context.Background()))
+			controllers = append(controllers, fc)
+		}
+		return controllers
+	}
+
+	// A standalone fireplace is always accessory 1.
+	controllers := newControllers(1234)
+	_, err := newServer(hap.NewMemStore(), controllers, config.HomeKit{})
+	r.NoError(err)
+	a.EqualValues(1, controllers[0].accessory.Id)
+
+	// Bridged fireplaces keep IDs from their serials, never the bridge's.
+	controllers = newControllers(1234, 1)
+	_, err = newServer(hap.NewMemStore(), controllers, config.HomeKit{})
+	r.NoError(err)
+	a.EqualValues(1235, controllers[0].accessory.Id)
+	a.EqualValues(2, controllers[1].accessory.Id)
+}
+
+func TestBridgeRunRejectsUnknownMode(t *testing.T) {
+	fp := firecontrol.NewFireplace(net.ParseIP("10.0.0.40"))
+	fp.Serial = 107757
+
+	b := NewBridge(WithStore(hap.NewMemStore()), WithFireplace("lounge", fp, config.Fireplace{}), WithHomeKit(config.HomeKit{Mode: "heatercooler"}))
+	assert.EqualError(t, b.Run(context.Background()), `unknown HomeKit mode "heatercooler", expected thermostat or heater-cooler`)
+}
+
+func TestBridgeRunRequiresSerials(t *testing.T) {
+	a := assert.New(t)
+
+	lounge := firecontrol.NewFireplace(net.ParseIP("10.0.0.40"))
+	lounge.Serial = 107757
+	unknown := firecontrol.NewFireplace(net.ParseIP("10.0.0.41"))
+
+	b := NewBridge(WithStore(hap.NewMemStore()), WithFireplace("lounge", lounge, config.Fireplace{}), WithFireplace("family-room", unknown, config.Fireplace{}))
+	a.EqualError(b.Run(context.Background()), "fireplace family-room has no serial")
+
+	unknown.Serial = lounge.Serial
+	b = NewBridge(WithStore(hap.NewMemStore()), WithFireplace("lounge", lounge, config.Fireplace{}), WithFireplace("family-room", unknown, config.Fireplace{}))
+	a.EqualError(b.Run(context.Background()), "fireplaces lounge and family-room have the same serial 107757")
+}
@@ -0,0 +1,619 @@
+package homekit
+
+import (
+	"context"
+	stderrors "errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brutella/hap/accessory"
+	"github.com/brutella/hap/characteristic"
+	"github.com/brutella/hap/service"
+	"github.com/pkg/errors"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/config"
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
+)
+
+var (
+	// ErrControllerStopped is returned for instructions sent to a controller
+	// that is no longer running.
+	ErrControllerStopped = stderrors.New("fireplace controller stopped")
+
+	// ErrInstructionTimeout is returned when the controller does not carry out
+	// an instruction in time, for example because the fireplace is not
+	// answering.
+	ErrInstructionTimeout = stderrors.New("timed out waiting for fireplace")
+
+	// ErrFireplaceUnreachable is returned for instructions sent before the
+	// fireplace has answered for the first time, and for temperatures set while
+	// it is not answering.
+	ErrFireplaceUnreachable = stderrors.New("fireplace is not reachable")
+)
+
+// instructionTimeout is how long a HomeKit request waits for its instruction to
+// be carried out. HomeKit gives up on an accessory after about 10 seconds.
+const instructionTimeout = 8 * time.Second
+
+// temperatureCoalesceWindow is how long the worker waits for further target
+// temperatures after receiving one, so dragging the slider in the Home app
+// only sends the last.
+const temperatureCoalesceWindow = 300 * time.Millisecond
+
+// defaultHysteresis is how many degrees below the target the room must fall
+// before an idling fireplace is shown as heating again.
+const defaultHysteresis = 1
+
+type (
+	FireplaceController struct {
+		name              string
+		serial            uint32
+		ip                string
+		fireplace         device
+		settings          config.Fireplace
+		mode              string
+		accessory         *accessory.A
+		thermostat        *service.Thermostat
+		heaterCooler      *heaterCooler
+		flameEffect       *service.Switch
+		fanBoost          *service.Switch
+		temperatureSensor *service.TemperatureSensor
+		presets           map[string]config.Preset
+		logger            *slog.Logger
+		refreshInterval   time.Duration
+
+		// exposeTemperatureSensor adds a temperature sensor for the room
+		// temperature, which unlike a thermostat can trigger automations.
+		exposeTemperatureSensor bool
+
+		// history keeps samples for the Eve app. It is nil in tests.
+		history *history
+
+		// monitor reports changes made by the remote or the Escea app as soon as
+		// it hears them on the network, and observe finds them in the statuses
+		// the worker reads. They are nil in tests.
+		monitor func(ctx context.Context, changes chan<- firecontrol.ExternalChange) error
+		observe func(status *firecontrol.Status) (firecontrol.ExternalChange, bool)
+
+		// probe checks that the fireplace answers and is the expected one
+		// before the controller starts using it, retrying every probeRetry,
+		// doubling up to maxProbeRetry, until it does. It is nil in tests.
+		probe      func() error
+		probeRetry time.Duration
+
+		// failures counts refreshes in a row that failed, faulted is set once
+		// there have been faultThreshold of them.
+		statusFault    *characteristic.StatusFault
+		faultThreshold int
+		failures       int
+		faulted        atomic.Bool
+
+		// locked is set while physical controls are locked from HomeKit, when
+		// changes made by the remote or the Escea app are undone.
+		locked bool
+
+		// heating is whether the fireplace was last thought to be heating rather
+		// than idling, see isHeating.
+		heating    bool
+		hysteresis int
+
+		// queue carries instructions to the worker, done is closed once the
+		// worker has stopped and timeout limits how long senders wait.
+		queue   chan Envelope
+		done    chan struct{}
+		timeout time.Duration
+
+		// coalesceWindow is how long target temperatures are collected for
+		// before the last is set, see coalesce.
+		coalesceWindow time.Duration
+	}
+
+	// device is the fireplace a controller operates, so tests can replace it
+	// with a fake.
+	device interface {
+		ReadStatus() (*firecontrol.Status, error)
+		PowerOn() error
+		PowerOff() error
+		SetTemperature(temp int) error
+		SetFlameEffect(on bool) error
+		SetFanBoost(on bool) error
+		Apply(desired firecontrol.DesiredState) (*firecontrol.ApplyResult, error)
+	}
+
+	// fireplaceDevice adapts a firecontrol.Fireplace to device.
+	fireplaceDevice struct {
+		*firecontrol.Fireplace
+	}
+
+	Instruction interface {
+		isInstruction()
+	}
+
+	internalInstruction struct {
+		responseChan chan error
+	}
+
+	SetTemperatureInstruction struct {
+		*internalInstruction
+		Temperature int
+	}
+
+	SetPowerInstruction struct {
+		*internalInstruction
+		Power bool
+	}
+
+	SetFlameEffectInstruction struct {
+		*internalInstruction
+		On bool
+	}
+
+	SetFanBoostInstruction struct {
+		*internalInstruction
+		On bool
+	}
+
+	SetLockInstruction struct {
+		*internalInstruction
+		Locked bool
+	}
+
+	ApplyPresetInstruction struct {
+		*internalInstruction
+		Name   string
+		Preset config.Preset
+	}
+
+	// refreshInstruction asks the worker to refresh the fireplace on behalf of
+	// the monitor, copying the status read into into.
+	refreshInstruction struct {
+		into *firecontrol.Status
+	}
+
+	Envelope struct {
+		Instruction  Instruction
+		responseChan chan error
+	}
+)
+
+func (i SetTemperatureInstruction) isInstruction() {}
+func (i SetPowerInstruction) isInstruction()       {}
+func (i SetFlameEffectInstruction) isInstruction() {}
+func (i SetFanBoostInstruction) isInstruction()    {}
+func (i SetLockInstruction) isInstruction()        {}
+func (i ApplyPresetInstruction) isInstruction()    {}
+func (i refreshInstruction) isInstruction()        {}
+
+func NewMessageEnvelope(instruction Instruction) Envelope {
+	return Envelope{Instruction: instruction, responseChan: make(chan error, 1)}
+}
+func (i Envelope) Complete(err error) {
+	if i.responseChan == nil {
+		return
+	}
+	i.responseChan <- err
+}
+
+func NewTemperatureInstruction(temp int) Instruction {
+	return SetTemperatureInstruction{
+		internalInstruction: &internalInstruction{responseChan: make(chan error, 1)},
+		Temperature:         temp,
+	}
+}
+
+func NewPowerInstruction(power bool) Instruction {
+	return SetPowerInstruction{
+		internalInstruction: &internalInstruction{responseChan: make(chan error, 1)},
+		Power:               power,
+	}
+}
+
+func NewFlameEffectInstruction(on bool) Instruction {
+	return SetFlameEffectInstruction{
+		internalInstruction: &internalInstruction{responseChan: make(chan error, 1)},
+		On:                  on,
+	}
+}
+
+func NewFanBoostInstruction(on bool) Instruction {
+	return SetFanBoostInstruction{
+		internalInstruction: &internalInstruction{responseChan: make(chan error, 1)},
+		On:                  on,
+	}
+}
+
+func NewLockInstruction(locked bool) Instruction {
+	return SetLockInstruction{
+		internalInstruction: &internalInstruction{responseChan: make(chan error, 1)},
+		Locked:              locked,
+	}
+}
+
+func NewApplyPresetInstruction(name string, preset config.Preset) Instruction {
+	return ApplyPresetInstruction{
+		internalInstruction: &internalInstruction{responseChan: make(chan error, 1)},
+		Name:                name,
+		Preset:              preset,
+	}
+}
+
+// ReadStatus refreshes the fireplace's status and returns it.
+func (d fireplaceDevice) ReadStatus() (*firecontrol.Status, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.Status, nil
+}
+
+// NewFireplaceController returns a controller exposing fp to HomeKit under name.
+func NewFireplaceController(name string, fp *firecontrol.Fireplace, settings config.Fireplace, presets map[string]config.Preset, refreshInterval time.Duration) *FireplaceController {
+	fc := newController(name, fireplaceDevice{fp}, settings, presets, refreshInterval)
+	fc.serial = fp.Serial
+	fc.ip = fp.Addr.IP.String()
+	fc.probe = fp.Identify
+
+	// The worker already refreshes regularly and carries out the monitor's
+	// reads, so nothing but the worker talks to the fireplace.
+	monitor := firecontrol.NewMonitor(fp)
+	monitor.PollInterval = 0
+	monitor.ReadStatus = fc.readStatus
+	fc.monitor = monitor.Run
+	fc.observe = monitor.Observe
+	return fc
+}
+
+func newController(name string, d device, settings config.Fireplace, presets map[string]config.Preset, refreshInterval time.Duration) *FireplaceController {
+	return &FireplaceController{
+		name:            name,
+		fireplace:       d,
+		settings:        settings,
+		presets:         presets,
+		refreshInterval: refreshInterval,
+		logger:          slog.Default(),
+		queue:           make(chan Envelope, 10),
+		done:            make(chan struct{}),
+		timeout:         instructionTimeout,
+		faultThreshold:  defaultFaultThreshold,
+		hysteresis:      defaultHysteresis,
+		probeRetry:      probeRetry,
+		coalesceWindow:  temperatureCoalesceWindow,
+	}
+}
+
+// Start runs the controller's worker until ctx is cancelled. The worker is the
+// only goroutine that talks to the fireplace: refreshes, external changes and
+// instructions from HomeKit are handled one at a time in the order they arrive.
+// A controller can only be started once.
+func (fc *FireplaceController) Start(ctx context.Context) error {
+	fc.logger.InfoContext(ctx, "Starting fireplace controller")
+
+	// The monitor may be waiting on the worker, so it is only waited for once
+	// the controller has stopped.
+	var monitor sync.WaitGroup
+	defer monitor.Wait()
+	defer fc.stop()
+
+	// A fireplace given by its address may not be answering yet, the rest of
+	// the bridge carries on without it in the meantime.
+	if fc.probe != nil && !fc.connect(ctx) {
+		fc.logger.InfoContext(ctx, "Stopping fireplace controller")
+		return nil
+	}
+
+	ticker := time.NewTicker(fc.refreshInterval)
+	defer ticker.Stop()
+
+	changes := make(chan firecontrol.ExternalChange)
+	if fc.monitor != nil {
+		monitor.Add(1)
+		go func() {
+			defer monitor.Done()
+			err := fc.monitor(ctx, changes)
+			if err != nil && ctx.Err() == nil {
+				fc.logger.ErrorContext(ctx, "Failed to monitor fireplace for external changes", "error", err)
+			}
+		}()
+	}
+
+	fc.refresh(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			fc.logger.InfoContext(ctx, "Stopping fireplace controller")
+			return nil
+
+		case change := <-changes:
+			fc.handleChange(ctx, change)
+
+		case <-ticker.C:
+			fc.refresh(ctx)
+
+		case msg := <-fc.queue:
+			fc.handle(ctx, msg)
+		}
+	}
+}
+
+// handle carries out the instruction in msg, along with any that arrive while
+// target temperatures are being coalesced. It must only be called by the
+// worker.
+func (fc *FireplaceController) handle(ctx context.Context, msg Envelope) {
+	fc.logger.DebugContext(ctx, "Received instruction", "instruction", msg.Instruction)
+
+	if i, ok := msg.Instruction.(refreshInstruction); ok {
+		status, err := fc.refresh(ctx)
+		if err == nil {
+			*i.into = *status
+		}
+		msg.Complete(err)
+		return
+	}
+
+	pending := []Envelope{msg}
+	var next *Envelope
+	if _, ok := msg.Instruction.(SetTemperatureInstruction); ok && fc.coalesceWindow > 0 {
+		pending, next = fc.coalesce(ctx, msg)
+	}
+
+	// Every caller waiting on a coalesced temperature gets the outcome of the
+	// last one.
+	last := pending[len(pending)-1]
+	if len(pending) > 1 {
+		fc.logger.DebugContext(ctx, "Coalesced target temperatures", "count", len(pending), "instruction", last.Instruction)
+	}
+	err := fc.execute(ctx, last.Instruction)
+	for _, p := range pending {
+		p.Complete(err)
+	}
+
+	// Temperatures from HomeKit are answered once queued, see
+	// setTemperatureFromHomeKit, so nobody else hears that one failed.
+	_, temperature := last.Instruction.(SetTemperatureInstruction)
+	if err != nil && temperature {
+		fc.logger.ErrorContext(ctx, "Failed to set target temperature", "error", err)
+	}
+	if err == nil || temperature {
+		// Show the result in HomeKit now rather than at the next refresh.
+		fc.refresh(ctx)
+	}
+
+	if next != nil {
+		fc.handle(ctx, *next)
+	}
+}
+
+// coalesce collects the target temperatures that arrive within coalesceWindow
+// of msg, returning them in order after msg. It stops early at any other
+// instruction, returning it as next so it is carried out after them.
+func (fc *FireplaceController) coalesce(ctx context.Context, msg Envelope) (pending []Envelope, next *Envelope) {
+	pending = []Envelope{msg}
+
+	timer := time.NewTimer(fc.coalesceWindow)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return pending, nil
+		case <-timer.C:
+			return pending, nil
+		case msg := <-fc.queue:
+			if _, ok := msg.Instruction.(SetTemperatureInstruction); !ok {
+				return pending, &msg
+			}
+			pending = append(pending, msg)
+		}
+	}
+}
+
+// handleChange shows a change made by the remote or the Escea app in HomeKit,
+// or undoes it while physical controls are locked. It must only be called by
+// the worker.
+func (fc *FireplaceController) handleChange(ctx context.Context, change firecontrol.ExternalChange) {
+	attrs := []any{
+		"source", change.Source,
+		"target-temperature", change.Current.TargetTempertaure,
+		"status", fireplaceStatusString(change.Current),
+	}
+	if change.From != nil {
+		attrs = append(attrs, "from", change.From.IP.String())
+	}
+	fc.logger.InfoContext(ctx, "Fireplace changed", attrs...)
+
+	if fc.locked && change.IsExternal() && change.Previous != nil {
+		fc.revert(ctx, change.Previous)
+		return
+	}
+	err := fc.updateCharacteristics(change.Current)
+	if err != nil {
+		fc.logger.ErrorContext(ctx, "Failed to update accessory", "error", err)
+	}
+}
+
+// readStatus has the worker refresh the fireplace and returns the status read,
+// so the monitor's reads never overlap the worker's own requests.
+func (fc *FireplaceController) readStatus() (*firecontrol.Status, error) {
+	status := new(firecontrol.Status)
+	if err := fc.send(refreshInstruction{into: status}); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// stop marks the controller as stopped and fails any instructions still queued.
+func (fc *FireplaceController) stop() {
+	close(fc.done)
+	for {
+		select {
+		case msg := <-fc.queue:
+			msg.Complete(ErrControllerStopped)
+		default:
+			return
+		}
+	}
+}
+
+// post queues instruction for the worker without waiting for it to be carried
+// out. It gives up if the fireplace is not answering, the worker has stopped or
+// the queue stays full.
+func (fc *FireplaceController) post(instruction Instruction) error {
+	if fc.faulted.Load() {
+		return ErrFireplaceUnreachable
+	}
+
+	timeout := time.NewTimer(fc.timeout)
+	defer timeout.Stop()
+
+	select {
+	case fc.queue <- NewMessageEnvelope(instruction):
+		return nil
+	case <-fc.done:
+		return ErrControllerStopped
+	case <-timeout.C:
+		return ErrInstructionTimeout
+	}
+}
+
+// send queues instruction for the worker and waits for it to be carried out.
+// It gives up if the worker has stopped or does not finish in time, so HomeKit
+// requests never hang.
+func (fc *FireplaceController) send(instruction Instruction) error {
+	msg := NewMessageEnvelope(instruction)
+
+	timeout := time.NewTimer(fc.timeout)
+	defer timeout.Stop()
+
+	select {
+	case fc.queue <- msg:
+	case <-fc.done:
+		return ErrControllerStopped
+	case <-timeout.C:
+		return ErrInstructionTimeout
+	}
+
+	select {
+	case err := <-msg.responseChan:
+		return err
+	case <-fc.done:
+		// The worker may have finished the instruction just before stopping.
+		select {
+		case err := <-msg.responseChan:
+			return err
+		default:
+			return ErrControllerStopped
+		}
+	case <-timeout.C:
+		return ErrInstructionTimeout
+	}
+}
+
+// execute carries out instruction. It must only be called by the worker.
+func (fc *FireplaceController) execute(ctx context.Context, instruction Instruction) error {
+	switch i := instruction.(type) {
+	case SetTemperatureInstruction:
+		return fc.setTargetTemperature(ctx, float64(i.Temperature))
+	case SetPowerInstruction:
+		if i.Power {
+			return fc.fireplace.PowerOn()
+		}
+		return fc.fireplace.PowerOff()
+	case SetFlameEffectInstruction:
+		return fc.fireplace.SetFlameEffect(i.On)
+	case SetFanBoostInstruction:
+		return fc.fireplace.SetFanBoost(i.On)
+	case SetLockInstruction:
+		fc.locked = i.Locked
+		return nil
+	case ApplyPresetInstruction:
+		return fc.applyPreset(ctx, i.Name, i.Preset)
+	}
+	return errors.Errorf("unknown instruction %T", instruction)
+}
+
+// refresh reads the fireplace's status and shows it in HomeKit, handling any
+// change made by the remote or the Escea app since the last read. It must only
+// be called by the worker.
+func (fc *FireplaceController) refresh(ctx context.Context) (*firecontrol.Status, error) {
+	status, err := fc.refreshStatus(ctx)
+	fc.recordRefresh(ctx, err)
+	if err != nil {
+		fc.logger.ErrorContext(ctx, "Failed to refresh fireplace", "error", err)
+		return nil, err
+	}
+
+	fc.logger.InfoContext(ctx, "Refreshed fireplace",
+		"room-temperature", status.CurrentTemperature,
+		"target-temperature", status.TargetTempertaure,
+		"status", fireplaceStatusString(status),
+	)
+
+	if fc.history != nil {
+		if err := fc.history.record(time.Now(), status); err != nil {
+			fc.logger.ErrorContext(ctx, "Failed to record history", "error", err)
+		}
+	}
+
+	if fc.observe != nil {
+		if change, ok := fc.observe(status); ok {
+			fc.handleChange(ctx, change)
+		}
+	}
+	return status, nil
+}
+
+func (fc *FireplaceController) refreshStatus(_ context.Context) (*firecontrol.Status, error) {
+	status, err := fc.fireplace.ReadStatus()
+	if err != nil {
+		return nil, errors.Wrap(err, "refreshing fireplace")
+	}
+
+	return status, fc.updateCharacteristics(status)
+}
+
+func (fc *FireplaceController) setTargetTemperature(ctx context.Context, temp float64) error {
+	fc.logger.InfoContext(ctx, "Setting target temperature", "temperature", temp)
+	err := fc.fireplace.SetTemperature(int(temp))
+	if err != nil {
+		return errors.Wrap(err, "setting target temperature")
+	}
+
+	return nil
+}
+
+// revert undoes a change made by the remote or the Escea app while physical
+// controls are locked, by returning the fireplace to its previous settings.
+func (fc *FireplaceController) revert(ctx context.Context, previous *firecontrol.Status) {
+	temperature := int(previous.TargetTempertaure)
+	result, err := fc.fireplace.Apply(firecontrol.DesiredState{
+		Power:             &previous.IsOn,
+		TargetTemperature: &temperature,
+		FlameEffect:       &previous.FlameEffectIsOn,
+		FanBoost:          &previous.FanBoostIsOn,
+	})
+	if err != nil {
+		fc.logger.ErrorContext(ctx, "Failed to undo change while controls are locked", "error", err)
+		fc.refresh(ctx)
+		return
+	}
+
+	fc.logger.InfoContext(ctx, "Undid change while controls are locked", "changes", len(result.Steps))
+	if fc.observe != nil {
+		fc.observe(result.After)
+	}
+	if err := fc.updateCharacteristics(result.After); err != nil {
+		fc.logger.ErrorContext(ctx, "Failed to update accessory", "error", err)
+	}
+}
+
+func (fc *FireplaceController) applyPreset(ctx context.Context, name string, preset config.Preset) error {
+	result, err := fc.fireplace.Apply(preset)
+	if err != nil {
+		return errors.Wrapf(err, "applying preset %s", name)
+	}
+
+	fc.logger.InfoContext(ctx, "Applied preset", "preset", name, "changes", len(result.Steps))
+	return fc.updateCharacteristics(result.After)
+}
@@ -0,0 +1,586 @@
+package homekit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brutella/hap/characteristic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/config"
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
+)
+
+// fakeFireplace is a device that keeps its status in memory and records
+// whether it was ever called from two goroutines at once.
+type fakeFireplace struct {
+	mu     sync.Mutex
+	status firecontrol.Status
+	calls  []string
+
+	// block, when set, holds every call until it is closed.
+	block chan struct{}
+	// err, when set, is returned by every call, and errs by calls with the
+	// given name.
+	err  error
+	errs map[string]error
+
+	inFlight   atomic.Int32
+	overlapped atomic.Bool
+}
+
+func (f *fakeFireplace) call(name string, change func(s *firecontrol.Status)) error {
+	if f.inFlight.Add(1) > 1 {
+		f.overlapped.Store(true)
+	}
+	defer f.inFlight.Add(-1)
+
+	if f.block != nil {
+		<-f.block
+	}
+	// Give overlapping calls a chance to be noticed.
+	time.Sleep(time.Millisecond)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, name)
+	if f.err != nil {
+		return f.err
+	}
+	if err := f.errs[name]; err != nil {
+		return err
+	}
+	if change != nil {
+		change(&f.status)
+	}
+	return nil
+}
+
+func (f *fakeFireplace) ReadStatus() (*firecontrol.Status, error) {
+	if err := f.call("status", nil); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	status := f.status
+	return &status, nil
+}
+
+func (f *fakeFireplace) PowerOn() error {
+	return f.call("power-on", func(s *firecontrol.Status) { s.IsOn = true })
+}
+
+func (f *fakeFireplace) PowerOff() error {
+	return f.call("power-off", func(s *firecontrol.Status) { s.IsOn = false })
+}
+
+func (f *fakeFireplace) SetTemperature(temp int) error {
+	return f.call("set-temp", func(s *firecontrol.Status) { s.TargetTempertaure = uint8(temp) })
+}
+
+func (f *fakeFireplace) SetFlameEffect(on bool) error {
+	return f.call("flame-effect", func(s *firecontrol.Status) { s.FlameEffectIsOn = on })
+}
+
+func (f *fakeFireplace) SetFanBoost(on bool) error {
+	return f.call("fan-boost", func(s *firecontrol.Status) { s.FanBoostIsOn = on })
+}
+
+func (f *fakeFireplace) Apply(desired firecontrol.DesiredState) (*firecontrol.ApplyResult, error) {
+	f.mu.Lock()
+	before := f.status
+	f.mu.Unlock()
+
+	err := f.call("apply", func(s *firecontrol.Status) {
+		if desired.Power != nil {
+			s.IsOn = *desired.Power
+		}
+		if desired.TargetTemperature != nil {
+			s.TargetTempertaure = uint8(*desired.TargetTemperature)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	after, _ := f.ReadStatus()
+	return &firecontrol.ApplyResult{Before: &before, After: after, Steps: firecontrol.Plan(&before, desired)}, nil
+}
+
+func (f *fakeFireplace) callCount(name string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, call := range f.calls {
+		if call == name {
+			n++
+		}
+	}
+	return n
+}
+
+// current returns the fake's status without counting as a call.
+func (f *fakeFireplace) current() firecontrol.Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.status
+}
+
+func (f *fakeFireplace) setErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+// startController starts a controller for fake and returns a function that
+// stops it and waits for it to return.
+func startController(t *testing.T, fake *fakeFireplace, configure ...func(fc *FireplaceController)) (*FireplaceController, func()) {
+	t.Helper()
+
+	on := true
+	presets := map[string]config.Preset{"cozy": {Power: &on}}
+	fc := newController("Fireplace", fake, config.Fireplace{}, presets, time.Hour)
+	for _, fn := range configure {
+		fn(fc)
+	}
+	require.NoError(t, fc.createAccessory(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- fc.Start(ctx) }()
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			cancel()
+			select {
+			case err := <-stopped:
+				assert.NoError(t, err)
+			case <-time.After(time.Second):
+				t.Error("controller did not stop")
+			}
+		})
+	}
+	t.Cleanup(stop)
+	return fc, stop
+}
+
+// setRemote sets c as if a paired HomeKit controller wrote v, returning the HAP
+// status code.
+func setRemote(c *characteristic.C, v interface{}) int {
+	_, code := c.SetValueRequest(v, httptest.NewRequest("PUT", "/characteristics", nil))
+	return code
+}
+
+func TestControllerSerializesInstructions(t *testing.T) {
+	a := assert.New(t)
+
+	fake := &fakeFireplace{status: firecontrol.Status{TargetTempertaure: 20}}
+	fc, _ := startController(t, fake, func(fc *FireplaceController) { fc.coalesceWindow = 0 })
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			switch i % 4 {
+			case 0:
+				err = fc.send(NewTemperatureInstruction(22))
+			case 1:
+				err = fc.send(NewPowerInstruction(true))
+			case 2:
+				err = fc.send(NewFlameEffectInstruction(true))
+			case 3:
+				err = fc.send(NewApplyPresetInstruction("cozy", fc.presets["cozy"]))
+			}
+			a.NoError(err)
+		}(i)
+	}
+	wg.Wait()
+
+	a.False(fake.overlapped.Load(), "fireplace was called concurrently")
+	a.Equal(5, fake.callCount("set-temp"))
+	a.Equal(5, fake.callCount("power-on"))
+	a.Equal(5, fake.callCount("flame-effect"))
+	a.Equal(5, fake.callCount("apply"))
+}
+
+func TestControllerHomeKitWrites(t *testing.T) {
+	a := assert.New(t)
+
+	fake := &fakeFireplace{status: firecontrol.Status{TargetTempertaure: 20, CurrentTemperature: 18}}
+	fc, _ := startController(t, fake)
+	th := fc.thermostat
+
+	a.Eventually(func() bool { return th.CurrentTemperature.Value() == 18 }, time.Second, 5*time.Millisecond)
+
+	a.Equal(0, setRemote(th.TargetHeatingCoolingState.C, characteristic.TargetHeatingCoolingStateHeat))
+	a.Equal(0, setRemote(th.TargetTemperature.C, 23.0))
+	a.Equal(0, setRemote(fc.fanBoost.On.C, true))
+
+	status := fake.current()
+	a.True(status.IsOn)
+	a.True(status.FanBoostIsOn)
+	a.EqualValues(23, status.TargetTempertaure)
+
+	// Turning off goes through the worker like everything else.
+	a.Equal(0, setRemote(th.TargetHeatingCoolingState.C, characteristic.TargetHeatingCoolingStateOff))
+	a.Equal(1, fake.callCount("power-off"))
+	a.False(fake.overlapped.Load())
+}
+
+func TestControllerCoalescesTemperatures(t *testing.T) {
+	a := assert.New(t)
+
+	fake := &fakeFireplace{status: firecontrol.Status{TargetTempertaure: 20}}
+	fc, _ := startController(t, fake, func(fc *FireplaceController) { fc.coalesceWindow = 200 * time.Millisecond })
+
+	// Dragging the slider, then turning the fireplace on before the window
+	// has passed.
+	instructions := []Instruction{
+		NewTemperatureInstruction(21),
+		NewTemperatureInstruction(22),
+		NewTemperatureInstruction(23),
+		NewPowerInstruction(true),
+	}
+	errs := make([]error, len(instructions))
+	var wg sync.WaitGroup
+	for i, instruction := range instructions {
+		wg.Add(1)
+		go func(i int, instruction Instruction) {
+			defer wg.Done()
+			errs[i] = fc.send(instruction)
+		}(i, instruction)
+		time.Sleep(10 * time.Millisecond)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		a.NoError(err)
+	}
+	a.Equal(1, fake.callCount("set-temp"), "only the last temperature is sent")
+	a.Equal(1, fake.callCount("power-on"))
+	status := fake.current()
+	a.EqualValues(23, status.TargetTempertaure)
+	a.True(status.IsOn)
+
+	// Every caller is told when the last temperature fails.
+	fake.setErr(errors.New("no answer"))
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = fc.send(NewTemperatureInstruction(24 + i))
+		}(i)
+	}
+	wg.Wait()
+	a.Error(errs[0])
+	a.Error(errs[1])
+}
+
+func TestControllerCoalescesSliderWrites(t *testing.T) {
+	a := assert.New(t)
+
+	fake := &fakeFireplace{status: firecontrol.Status{IsOn: true, TargetTempertaure: 20}}
+	fc, _ := startController(t, fake, func(fc *FireplaceController) { fc.coalesceWindow = 200 * time.Millisecond })
+	target := fc.thermostat.TargetTemperature
+
+	// A HomeKit controller only sends a write once the last is answered, as
+	// the Home app does while the slider is dragged.
+	start := time.Now()
+	for temp := 21.0; temp <= 24; temp++ {
+		a.Equal(0, setRemote(target.C, temp))
+	}
+	a.Less(time.Since(start), fc.coalesceWindow, "writes are answered without waiting for the fireplace")
+
+	a.Eventually(func() bool { return fake.current().TargetTempertaure == 24 }, time.Second, 5*time.Millisecond)
+	a.Equal(1, fake.callCount("set-temp"), "only the last temperature is sent")
+
+	// A temperature the fireplace does not take is replaced by its own.
+	fake.mu.Lock()
+	fake.errs = map[string]error{"set-temp": errors.New("no answer")}
+	fake.mu.Unlock()
+	a.Equal(0, setRemote(target.C, 25.0))
+	a.Eventually(func() bool { return target.Value() == 24 }, time.Second, 5*time.Millisecond)
+}
+
+func TestControllerReportsErrors(t *testing.T) {
+	fake := &fakeFireplace{err: errors.New("no answer")}
+	fc, _ := startController(t, fake)
+
+	assert.Equal(t, hapStatusCommunicationFailure, setRemote(fc.thermostat.TargetHeatingCoolingState.C, characteristic.TargetHeatingCoolingStateHeat))
+}
+
+func TestControllerTimesOut(t *testing.T) {
+	a := assert.New(t)
+
+	fake := &fakeFireplace{block: make(chan struct{})}
+	fc, stop := startController(t, fake)
+	fc.timeout = 20 * time.Millisecond
+
+	// The worker is stuck on its first refresh.
+	a.ErrorIs(fc.send(NewPowerInstruction(true)), ErrInstructionTimeout)
+	a.Equal(hapStatusTimedOut, setRemote(fc.flameEffect.On.C, true))
+
+	close(fake.block)
+	stop()
+}
+
+func TestControllerStop(t *testing.T) {
+	a := assert.New(t)
+
+	fake := &fakeFireplace{}
+	fc, stop := startController(t, fake)
+	a.NoError(fc.send(NewPowerInstruction(true)))
+
+	stop()
+
+	a.ErrorIs(fc.send(NewPowerInstruction(false)), ErrControllerStopped)
+	a.NotPanics(func() {
+		a.Equal(hapStatusCommunicationFailure, setRemote(fc.thermostat.TargetHeatingCoolingState.C, characteristic.TargetHeatingCoolingStateOff))
+	})
+	a.Equal(0, fake.callCount("power-off"))
+}
+
+func TestControllerFault(t *testing.T) {
+	a := assert.New(t)
+
+	fake := &fakeFireplace{status: firecontrol.Status{CurrentTemperature: 18}, err: errors.New("no answer")}
+	fc, _ := startController(t, fake, func(fc *FireplaceController) {
+		fc.refreshInterval = 5 * time.Millisecond
+		fc.faultThreshold = 3
+	})
+	th := fc.thermostat
+	read := func(c *characteristic.C) int {
+		_, code := c.ValueRequest(httptest.NewRequest("GET", "/characteristics", nil))
+		return code
+	}
+
+	a.Eventually(func() bool { return fc.statusFault.Value() == characteristic.StatusFaultGeneralFault }, time.Second, 5*time.Millisecond)
+	a.GreaterOrEqual(fake.callCount("status"), 3)
+	a.Equal(hapStatusCommunicationFailure, read(th.CurrentTemperature.C))
+	a.Equal(hapStatusCommunicationFailure, read(fc.flameEffect.On.C))
+	a.Equal(hapStatusSuccess, read(fc.statusFault.C))
+	a.Equal(hapStatusSuccess, read(fc.accessory.Info.SerialNumber.C))
+
+	fake.setErr(nil)
+	a.Eventually(func() bool { return fc.statusFault.Value() == characteristic.StatusFaultNoFault }, time.Second, 5*time.Millisecond)
+	a.Equal(hapStatusSuccess, read(th.CurrentTemperature.C))
+	a.EqualValues(18, th.CurrentTemperature.Value())
+}
+
+func TestHAPStatus(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal(hapStatusSuccess, hapStatus(nil))
+	a.Equal(hapStatusTimedOut, hapStatus(ErrInstructionTimeout))
+	a.Equal(hapStatusInvalidValue, hapStatus(fmt.Errorf("setting: %w", firecontrol.ErrInvalidTemperature)))
+	a.Equal(hapStatusCommunicationFailure, hapStatus(ErrControllerStopped))
+	a.Equal(hapStatusCommunicationFailure, hapStatus(errors.New("i/o timeout")))
+}
+
+func TestControllerUpdatesAfterCommands(t *testing.T) {
+	a := assert.New(t)
+
+	fake := &fakeFireplace{status: firecontrol.Status{TargetTempertaure: 22, CurrentTemperature: 18}}
+	fc, _ := startController(t, fake)
+	th := fc.thermostat
+
+	a.NoError(fc.send(NewPowerInstruction(true)))
+	a.Eventually(func() bool {
+		return th.CurrentHeatingCoolingState.Value() == characteristic.CurrentHeatingCoolingStateHeat
+	}, time.Second, 5*time.Millisecond)
+	a.Equal(characteristic.TargetHeatingCoolingStateHeat, th.TargetHeatingCoolingState.Value())
+
+	// Lowering the target below the room temperature leaves the fire idling.
+	a.NoError(fc.send(NewTemperatureInstruction(17)))
+	a.Eventually(func() bool {
+		return th.CurrentHeatingCoolingState.Value() == characteristic.CurrentHeatingCoolingStateOff
+	}, time.Second, 5*time.Millisecond)
+	a.EqualValues(17, th.TargetTemperature.Value())
+}
+
+func TestControllerProbesUntilReachable(t *testing.T) {
+	a := assert.New(t)
+
+	var probes atomic.Int32
+	reachable := make(chan struct{})
+	fake := &fakeFireplace{status: firecontrol.Status{CurrentTemperature: 18}}
+	fc, _ := startController(t, fake, func(fc *FireplaceController) {
+		fc.probeRetry = time.Millisecond
+		fc.probe = func() error {
+			probes.Add(1)
+			select {
+			case <-reachable:
+				return nil
+			default:
+				return errors.New("no answer")
+			}
+		}
+	})
+
+	// The fault is reported straight away and instructions fail until the
+	// fireplace answers.
+	a.Eventually(func() bool { return fc.statusFault.Value() == characteristic.StatusFaultGeneralFault }, time.Second, time.Millisecond)
+	a.ErrorIs(fc.send(NewPowerInstruction(true)), ErrFireplaceUnreachable)
+	a.Equal(0, fake.callCount("status"))
+
+	close(reachable)
+	a.Eventually(func() bool { return fc.statusFault.Value() == characteristic.StatusFaultNoFault }, time.Second, time.Millisecond)
+	a.Greater(probes.Load(), int32(1))
+	a.NoError(fc.send(NewPowerInstruction(true)))
+	a.True(fake.current().IsOn)
+}
+
+func TestControllerTemperatureSensor(t *testing.T) {
+	a := assert.New(t)
+
+	fake := &fakeFireplace{status: firecontrol.Status{CurrentTemperature: 18}}
+	fc, _ := startController(t, fake)
+	a.Nil(fc.temperatureSensor, "the sensor is only added when asked for")
+
+	fc, _ = startController(t, fake, func(fc *FireplaceController) { fc.exposeTemperatureSensor = true })
+	a.Eventually(func() bool {
+		return fc.temperatureSensor.CurrentTemperature.Value() == 18
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestControllerHeaterCooler(t *testing.T) {
+	a := assert.New(t)
+
+	fake := &fakeFireplace{status: firecontrol.Status{TargetTempertaure: 20, CurrentTemperature: 18}}
+	fc, _ := startController(t, fake, func(fc *FireplaceController) { fc.mode = config.HomeKitModeHeaterCooler })
+	a.Nil(fc.thermostat)
+	a.Nil(fc.fanBoost, "fan boost is the rotation speed")
+	hc := fc.heaterCooler
+
+	a.Eventually(func() bool { return hc.CurrentTemperature.Value() == 18 }, time.Second, 5*time.Millisecond)
+	a.Equal(characteristic.CurrentHeaterCoolerStateInactive, hc.CurrentHeaterCoolerState.Value())
+
+	a.Equal(0, setRemote(hc.Active.C, characteristic.ActiveActive))
+	a.Equal(0, setRemote(hc.HeatingThresholdTemperature.C, 23.0))
+	a.Equal(0, setRemote(hc.RotationSpeed.C, 100.0))
+
+	status := fake.current()
+	a.True(status.IsOn)
+	a.True(status.FanBoostIsOn)
+	a.EqualValues(23, status.TargetTempertaure)
+	a.Eventually(func() bool {
+		return hc.CurrentHeaterCoolerState.Value() == characteristic.CurrentHeaterCoolerStateHeating
+	}, time.Second, 5*time.Millisecond)
+
+	a.Equal(0, setRemote(hc.Active.C, characteristic.ActiveInactive))
+	a.Equal(1, fake.callCount("power-off"))
+	a.Eventually(func() bool {
+		return hc.CurrentHeaterCoolerState.Value() == characteristic.CurrentHeaterCoolerStateInactive
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestHeaterCoolerTemperatureRange(t *testing.T) {
+	a := assert.New(t)
+
+	fake := &fakeFireplace{}
+	fc, _ := startController(t, fake, func(fc *FireplaceController) { fc.mode = config.HomeKitModeHeaterCooler })
+	a.EqualValues(firecontrol.MinTemperature, fc.heaterCooler.HeatingThresholdTemperature.MinValue())
+	a.EqualValues(firecontrol.MaxTemperature, fc.heaterCooler.HeatingThresholdTemperature.MaxValue())
+}
+
+func TestControllerLockPhysicalControls(t *testing.T) {
+	a := assert.New(t)
+
+	changes := make(chan firecontrol.ExternalChange)
+	fake := &fakeFireplace{status: firecontrol.Status{IsOn: true, TargetTempertaure: 20}}
+	fc, _ := startController(t, fake, func(fc *FireplaceController) {
+		fc.mode = config.HomeKitModeHeaterCooler
+		fc.monitor = func(ctx context.Context, out chan<- firecontrol.ExternalChange) error {
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case change := <-changes:
+					out <- change
+				}
+			}
+		}
+	})
+
+	a.Equal(0, setRemote(fc.heaterCooler.LockPhysicalControls.C, characteristic.LockPhysicalControlsControlLockEnabled))
+
+	// The remote turns the fireplace down while locked, which is undone.
+	previous := fake.current()
+	fake.mu.Lock()
+	fake.status.TargetTempertaure = 15
+	current := fake.status
+	fake.mu.Unlock()
+	changes <- firecontrol.ExternalChange{Source: firecontrol.SourceRemote, Previous: &previous, Current: &current}
+
+	a.Eventually(func() bool { return fake.current().TargetTempertaure == 20 }, time.Second, 5*time.Millisecond)
+	a.Equal(1, fake.callCount("apply"))
+}
+
+func TestControllerMonitorReadsThroughWorker(t *testing.T) {
+	a := assert.New(t)
+	r := require.New(t)
+
+	fake := &fakeFireplace{status: firecontrol.Status{IsOn: true, TargetTempertaure: 20}}
+	monitor := firecontrol.NewMonitor(firecontrol.NewFireplace(net.ParseIP("10.0.0.60")))
+	fc, _ := startController(t, fake, func(fc *FireplaceController) {
+		fc.mode = config.HomeKitModeHeaterCooler
+		fc.observe = monitor.Observe
+	})
+	a.Equal(0, setRemote(fc.heaterCooler.LockPhysicalControls.C, characteristic.LockPhysicalControlsControlLockEnabled))
+	// Wait for the refresh that follows the instruction.
+	a.Eventually(func() bool { return fake.callCount("status") == 2 }, time.Second, 5*time.Millisecond)
+
+	// The remote turns the fireplace down while locked. The monitor's read is
+	// carried out by the worker, which notices the change and undoes it.
+	fake.mu.Lock()
+	fake.status.TargetTempertaure = 15
+	fake.mu.Unlock()
+
+	status, err := fc.readStatus()
+	r.NoError(err)
+	a.EqualValues(15, status.TargetTempertaure)
+	a.EqualValues(20, fake.current().TargetTempertaure)
+	a.Equal(1, fake.callCount("apply"))
+	a.False(fake.overlapped.Load())
+
+	// Undoing the change is not taken for another one.
+	_, err = fc.readStatus()
+	r.NoError(err)
+	a.Equal(1, fake.callCount("apply"))
+}
+
+func TestIsHeating(t *testing.T) {
+	tests := []struct {
+		name       string
+		on         bool
+		room       uint8
+		wasHeating bool
+		want       bool
+	}{
+		{"off", false, 15, true, false},
+		{"cold room", true, 18, false, true},
+		{"reached target", true, 22, true, false},
+		{"above target", true, 24, true, false},
+		{"within hysteresis while heating", true, 21, true, true},
+		{"within hysteresis while idling", true, 21, false, false},
+		{"below hysteresis", true, 20, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := &firecontrol.Status{IsOn: tt.on, CurrentTemperature: tt.room, TargetTempertaure: 22}
+			assert.Equal(t, tt.want, isHeating(status, tt.wasHeating, 2))
+		})
+	}
+}
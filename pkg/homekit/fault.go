@@ -0,0 +1,140 @@
+package homekit
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/brutella/hap/accessory"
+	"github.com/brutella/hap/characteristic"
+	"github.com/brutella/hap/service"
+	"github.com/pkg/errors"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
+)
+
+// HAP status codes returned to HomeKit controllers.
+const (
+	hapStatusSuccess              = 0
+	hapStatusCommunicationFailure = -70402
+	hapStatusTimedOut             = -70408
+	hapStatusInvalidValue         = -70410
+)
+
+// defaultFaultThreshold is how many refreshes in a row must fail before the
+// accessory reports a fault, when the configuration file does not set it.
+const defaultFaultThreshold = 3
+
+// How long to wait before probing a fireplace that has never answered again,
+// doubling after every failed probe up to maxProbeRetry.
+const (
+	probeRetry    = 5 * time.Second
+	maxProbeRetry = 5 * time.Minute
+)
+
+// hapStatus returns the HAP status code describing err.
+func hapStatus(err error) int {
+	switch {
+	case err == nil:
+		return hapStatusSuccess
+	case errors.Is(err, ErrInstructionTimeout):
+		return hapStatusTimedOut
+	case errors.Is(err, firecontrol.ErrInvalidTemperature):
+		return hapStatusInvalidValue
+	}
+	return hapStatusCommunicationFailure
+}
+
+// onRemoteWrite calls fn when a HomeKit controller writes to c, answering with
+// the HAP status code for the error fn returns. Unlike OnSetRemoteValue it does
+// not report every error as a communication failure.
+func onRemoteWrite[T any](c *characteristic.C, fn func(v T) error) {
+	c.SetValueRequestFunc = func(v interface{}, _ *http.Request) (interface{}, int) {
+		return nil, hapStatus(fn(v.(T)))
+	}
+}
+
+// guardReads makes HomeKit reads of the accessory's state fail while the
+// fireplace is unreachable, so the Home app shows it as not responding instead
+// of stale values.
+func (fc *FireplaceController) guardReads(acc *accessory.A) {
+	for _, s := range acc.Ss {
+		if s.Type == service.TypeAccessoryInformation {
+			continue
+		}
+		for _, c := range s.Cs {
+			if c.Type == characteristic.TypeStatusFault || c.Type == characteristic.TypeName {
+				continue
+			}
+			c := c
+			c.ValueRequestFunc = func(*http.Request) (interface{}, int) {
+				if fc.faulted.Load() {
+					return nil, hapStatusCommunicationFailure
+				}
+				return c.Value(), hapStatusSuccess
+			}
+		}
+	}
+}
+
+// recordRefresh tracks consecutive failed refreshes, reporting a fault once
+// there have been faultThreshold of them and clearing it when a refresh
+// succeeds. It must only be called by the worker.
+func (fc *FireplaceController) recordRefresh(ctx context.Context, err error) {
+	if err == nil {
+		fc.failures = 0
+		if fc.faulted.Swap(false) {
+			fc.logger.InfoContext(ctx, "Fireplace is answering again, clearing fault")
+			fc.statusFault.SetValue(characteristic.StatusFaultNoFault)
+		}
+		return
+	}
+
+	fc.failures++
+	if fc.failures >= fc.faultThreshold && !fc.faulted.Swap(true) {
+		fc.logger.WarnContext(ctx, "Fireplace is not answering, reporting fault", "failures", fc.failures)
+		fc.statusFault.SetValue(characteristic.StatusFaultGeneralFault)
+	}
+}
+
+// connect probes the fireplace until it answers, reporting a fault from the
+// first failed probe and failing instructions in the meantime. It returns false
+// if ctx is cancelled first. It must only be called by the worker.
+func (fc *FireplaceController) connect(ctx context.Context) bool {
+	delay := fc.probeRetry
+	for {
+		err := fc.probe()
+		if err == nil {
+			// The first refresh clears the fault.
+			return true
+		}
+
+		fc.logger.WarnContext(ctx, "Fireplace is not reachable, retrying", "error", err, "retry-in", delay)
+		if !fc.faulted.Swap(true) {
+			fc.statusFault.SetValue(characteristic.StatusFaultGeneralFault)
+		}
+
+		if !fc.waitToProbe(ctx, delay) {
+			return false
+		}
+		delay = min(delay*2, maxProbeRetry)
+	}
+}
+
+// waitToProbe waits for d, failing any instructions that arrive meanwhile. It
+// returns false if ctx is cancelled first.
+func (fc *FireplaceController) waitToProbe(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		case msg := <-fc.queue:
+			msg.Complete(ErrFireplaceUnreachable)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package homekit
+
+import (
+	"context"
+
+	"github.com/brutella/hap/characteristic"
+	"github.com/brutella/hap/service"
+	"github.com/pkg/errors"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/config"
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
+)
+
+// heaterCooler is the heater cooler service controlling the fireplace, an
+// alternative to the thermostat that only offers heating.
+type heaterCooler struct {
+	*service.HeaterCooler
+
+	HeatingThresholdTemperature *characteristic.HeatingThresholdTemperature
+	LockPhysicalControls        *characteristic.LockPhysicalControls
+
+	// RotationSpeed shows fan boost, off below half speed. It is nil if the
+	// fireplace has no fan boost.
+	RotationSpeed *characteristic.RotationSpeed
+}
+
+// newHeaterCooler returns the heater cooler service controlling the fireplace.
+func (fc *FireplaceController) newHeaterCooler(ctx context.Context) *heaterCooler {
+	hc := &heaterCooler{
+		HeaterCooler:                service.NewHeaterCooler(),
+		HeatingThresholdTemperature: characteristic.NewHeatingThresholdTemperature(),
+		LockPhysicalControls:        characteristic.NewLockPhysicalControls(),
+	}
+
+	onRemoteWrite(hc.Active.C, func(active int) error {
+		on := active == characteristic.ActiveActive
+		fc.logger.InfoContext(ctx, "Active set", "active", active, "power", on)
+		return fc.setPowerFromHomeKit(ctx, on)
+	})
+
+	// The fireplace can only heat.
+	hc.TargetHeaterCoolerState.ValidVals = []int{characteristic.TargetHeaterCoolerStateHeat}
+	hc.TargetHeaterCoolerState.SetValue(characteristic.TargetHeaterCoolerStateHeat)
+
+	hc.HeatingThresholdTemperature.SetMinValue(firecontrol.MinTemperature)
+	hc.HeatingThresholdTemperature.SetMaxValue(firecontrol.MaxTemperature)
+	hc.HeatingThresholdTemperature.SetStepValue(1)
+	onRemoteWrite(hc.HeatingThresholdTemperature.C, func(v float64) error {
+		return fc.setTemperatureFromHomeKit(ctx, v)
+	})
+	hc.AddC(hc.HeatingThresholdTemperature.C)
+
+	onRemoteWrite(hc.LockPhysicalControls.C, func(lock int) error {
+		locked := lock == characteristic.LockPhysicalControlsControlLockEnabled
+		fc.logger.InfoContext(ctx, "Lock physical controls set", "locked", locked)
+		return fc.send(NewLockInstruction(locked))
+	})
+	hc.AddC(hc.LockPhysicalControls.C)
+
+	if fc.settings.Has(config.CapabilityFanBoost) {
+		hc.RotationSpeed = characteristic.NewRotationSpeed()
+		hc.RotationSpeed.SetStepValue(100)
+		onRemoteWrite(hc.RotationSpeed.C, func(speed float64) error {
+			on := speed >= 50
+			fc.logger.InfoContext(ctx, "Rotation speed set", "speed", speed, "fan-boost", on)
+			if err := fc.send(NewFanBoostInstruction(on)); err != nil {
+				fc.logger.ErrorContext(ctx, "Failed to set fan boost", "error", err, "fan-boost", on)
+				return errors.Wrap(err, "setting fan boost")
+			}
+			return nil
+		})
+		hc.AddC(hc.RotationSpeed.C)
+	}
+
+	return hc
+}
+
+func (fc *FireplaceController) updateHeaterCooler(status *firecontrol.Status) error {
+	hc := fc.heaterCooler
+	hc.CurrentTemperature.SetValue(float64(status.CurrentTemperature))
+	hc.HeatingThresholdTemperature.SetValue(float64(status.TargetTempertaure))
+
+	if hc.RotationSpeed != nil {
+		speed := 0.0
+		if status.FanBoostIsOn {
+			speed = 100
+		}
+		hc.RotationSpeed.SetValue(speed)
+	}
+
+	active := characteristic.ActiveInactive
+	current := characteristic.CurrentHeaterCoolerStateInactive
+	switch {
+	case status.IsOn && fc.heating:
+		active, current = characteristic.ActiveActive, characteristic.CurrentHeaterCoolerStateHeating
+	case status.IsOn:
+		active, current = characteristic.ActiveActive, characteristic.CurrentHeaterCoolerStateIdle
+	}
+	if err := hc.Active.SetValue(active); err != nil {
+		return errors.Wrap(err, "setting active")
+	}
+	if err := hc.CurrentHeaterCoolerState.SetValue(current); err != nil {
+		return errors.Wrap(err, "setting current heater cooler state")
+	}
+	return nil
+}
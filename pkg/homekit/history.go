@@ -0,0 +1,269 @@
+package homekit
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/brutella/hap"
+	"github.com/brutella/hap/characteristic"
+	"github.com/brutella/hap/service"
+	"github.com/pkg/errors"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
+)
+
+// The Eve history service and its characteristics. The Eve app reads the
+// history status, asks for entries from a given entry number by writing a
+// history request and then reads the entries until it gets an empty response.
+const (
+	typeEveHistory        = "E863F007-079E-48FF-8F27-9C2605A29F52"
+	typeEveHistoryStatus  = "E863F116-079E-48FF-8F27-9C2605A29F52"
+	typeEveHistoryEntries = "E863F117-079E-48FF-8F27-9C2605A29F52"
+	typeEveHistoryRequest = "E863F11C-079E-48FF-8F27-9C2605A29F52"
+	typeEveSetTime        = "E863F121-079E-48FF-8F27-9C2605A29F52"
+)
+
+const (
+	// eveEpoch is the start of the Eve history clock, 2001-01-01 UTC.
+	eveEpoch = 978307200
+
+	// historyInterval is how often a sample is kept. The Eve app expects
+	// samples 10 minutes apart.
+	historyInterval = 10 * time.Minute
+
+	// historySize is how many samples are kept, 4 weeks at historyInterval.
+	historySize = 4032
+
+	// historyBatch is how many entries are sent for each read.
+	historyBatch = 11
+
+	// historyKeyPrefix is the prefix of the store keys history is kept under.
+	historyKeyPrefix = firecontrolKeyPrefix + "history-"
+)
+
+// eveThermoSignature describes the fields of an Eve Thermo entry: room
+// temperature, target temperature, valve position, thermostat target and
+// open window, each as a type and a length.
+var eveThermoSignature = []byte{0x05, 0x01, 0x02, 0x11, 0x02, 0x10, 0x01, 0x12, 0x01, 0x1d, 0x01}
+
+type (
+	// historyEntry is a sample of the fireplace's state. Reference entries
+	// mark the time the history starts at and carry no sample.
+	historyEntry struct {
+		Time              int64   `json:"time"`
+		RoomTemperature   float64 `json:"room_temperature,omitempty"`
+		TargetTemperature float64 `json:"target_temperature,omitempty"`
+		Power             bool    `json:"power,omitempty"`
+		Reference         bool    `json:"reference,omitempty"`
+	}
+
+	// historyData is the persisted history. Entries are numbered from 1,
+	// LastEntry being the number of the last one.
+	historyData struct {
+		RefTime   uint32         `json:"ref_time"`
+		LastEntry uint32         `json:"last_entry"`
+		Entries   []historyEntry `json:"entries"`
+	}
+
+	// history implements the Eve history service for a fireplace, keeping its
+	// samples in the HAP store so they survive restarts.
+	history struct {
+		*service.S
+
+		mu    sync.Mutex
+		store hap.Store
+		key   string
+		data  historyData
+
+		// next is the number of the next entry to send to the Eve app.
+		next uint32
+
+		status  *characteristic.Bytes
+		entries *characteristic.Bytes
+		request *characteristic.Bytes
+		setTime *characteristic.Bytes
+	}
+)
+
+// newHistory returns the history kept in st under key, loading any samples
+// stored by an earlier run.
+func newHistory(st hap.Store, key string) (*history, error) {
+	h := &history{
+		S:       service.New(typeEveHistory),
+		store:   st,
+		key:     key,
+		status:  eveCharacteristic(typeEveHistoryStatus, characteristic.PermissionRead, characteristic.PermissionEvents),
+		entries: eveCharacteristic(typeEveHistoryEntries, characteristic.PermissionRead, characteristic.PermissionEvents),
+		request: eveCharacteristic(typeEveHistoryRequest, characteristic.PermissionWrite),
+		setTime: eveCharacteristic(typeEveSetTime, characteristic.PermissionWrite),
+	}
+	h.S.Hidden = true
+	h.AddC(h.status.C)
+	h.AddC(h.entries.C)
+	h.AddC(h.request.C)
+	h.AddC(h.setTime.C)
+
+	if b, err := st.Get(key); err == nil {
+		if err := json.Unmarshal(b, &h.data); err != nil {
+			return nil, errors.Wrap(err, "reading history")
+		}
+	}
+
+	h.entries.ValueRequestFunc = func(*http.Request) (interface{}, int) {
+		return base64.StdEncoding.EncodeToString(h.nextEntries()), hapStatusSuccess
+	}
+	h.request.OnValueRemoteUpdate(func(v []byte) {
+		h.requestFrom(v)
+		// hap ignores writes of the value a characteristic already has, so
+		// clear it for the Eve app to ask for the same entries again.
+		h.request.SetValueRequest(base64.StdEncoding.EncodeToString(nil), nil)
+	})
+
+	h.mu.Lock()
+	h.status.SetValue(h.statusValue())
+	h.mu.Unlock()
+	return h, nil
+}
+
+func eveCharacteristic(typ string, perms ...string) *characteristic.Bytes {
+	c := characteristic.NewBytes(typ)
+	c.Format = characteristic.FormatData
+	c.Permissions = append(perms, characteristic.PermissionHidden)
+	c.SetValue([]byte{})
+	return c
+}
+
+// record adds a sample of status taken at now, unless the last sample is less
+// than historyInterval old.
+func (h *history) record(now time.Time, status *firecontrol.Status) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if n := len(h.data.Entries); n > 0 && now.Sub(time.Unix(h.data.Entries[n-1].Time, 0)) < historyInterval {
+		return nil
+	}
+
+	if h.data.RefTime == 0 {
+		h.data.RefTime = uint32(now.Unix() - eveEpoch)
+		h.add(historyEntry{Time: now.Unix(), Reference: true})
+	}
+	h.add(historyEntry{
+		Time:              now.Unix(),
+		RoomTemperature:   float64(status.CurrentTemperature),
+		TargetTemperature: float64(status.TargetTempertaure),
+		Power:             status.IsOn,
+	})
+
+	h.status.SetValue(h.statusValue())
+
+	b, err := json.Marshal(h.data)
+	if err != nil {
+		return errors.Wrap(err, "saving history")
+	}
+	return errors.Wrap(h.store.Set(h.key, b), "saving history")
+}
+
+func (h *history) add(entry historyEntry) {
+	h.data.Entries = append(h.data.Entries, entry)
+	if len(h.data.Entries) > historySize {
+		h.data.Entries = h.data.Entries[len(h.data.Entries)-historySize:]
+	}
+	h.data.LastEntry++
+}
+
+// firstEntry returns the number of the entry before the oldest one kept.
+func (h *history) firstEntry() uint32 {
+	return h.data.LastEntry - uint32(len(h.data.Entries))
+}
+
+// statusValue returns the history status read by the Eve app.
+func (h *history) statusValue() []byte {
+	var lastTime uint32
+	if n := len(h.data.Entries); n > 0 {
+		lastTime = uint32(h.data.Entries[n-1].Time-eveEpoch) - h.data.RefTime
+	}
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, lastTime)
+	binary.Write(&b, binary.LittleEndian, uint32(0))
+	binary.Write(&b, binary.LittleEndian, h.data.RefTime)
+	b.Write(eveThermoSignature)
+	binary.Write(&b, binary.LittleEndian, uint16(len(h.data.Entries)))
+	binary.Write(&b, binary.LittleEndian, uint16(historySize))
+	binary.Write(&b, binary.LittleEndian, h.firstEntry())
+	b.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x01, 0x01})
+	return b.Bytes()
+}
+
+// requestFrom handles a history request, which carries the number of the
+// first entry the Eve app wants.
+func (h *history) requestFrom(request []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.next = 1
+	if len(request) >= 6 {
+		h.next = max(binary.LittleEndian.Uint32(request[2:6]), 1)
+	}
+}
+
+// nextEntries returns the next batch of requested entries, or a single zero
+// byte once they have all been sent.
+func (h *history) nextEntries() []byte {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	first := h.firstEntry()
+	if h.next <= first {
+		h.next = first + 1
+	}
+	if h.next == 0 || h.next > h.data.LastEntry {
+		return []byte{0x00}
+	}
+
+	var b bytes.Buffer
+	for i := 0; i < historyBatch && h.next <= h.data.LastEntry; i++ {
+		entry := h.data.Entries[h.next-first-1]
+		if entry.Reference || h.next == first+1 {
+			h.writeReference(&b, h.next)
+		} else {
+			h.writeEntry(&b, h.next, entry)
+		}
+		h.next++
+	}
+	return b.Bytes()
+}
+
+// writeReference writes the entry telling the Eve app the time the history's
+// offsets are relative to.
+func (h *history) writeReference(b *bytes.Buffer, n uint32) {
+	b.WriteByte(0x15)
+	binary.Write(b, binary.LittleEndian, n)
+	binary.Write(b, binary.LittleEndian, uint32(1))
+	b.WriteByte(0x81)
+	binary.Write(b, binary.LittleEndian, h.data.RefTime)
+	b.Write(make([]byte, 7))
+}
+
+// writeEntry writes a sample as an Eve Thermo entry, showing the valve fully
+// open while the fireplace is on.
+func (h *history) writeEntry(b *bytes.Buffer, n uint32, entry historyEntry) {
+	var valve uint8
+	if entry.Power {
+		valve = 100
+	}
+
+	b.WriteByte(0x11)
+	binary.Write(b, binary.LittleEndian, n)
+	binary.Write(b, binary.LittleEndian, uint32(entry.Time-eveEpoch)-h.data.RefTime)
+	b.WriteByte(0x1f)
+	binary.Write(b, binary.LittleEndian, uint16(entry.RoomTemperature*100))
+	binary.Write(b, binary.LittleEndian, uint16(entry.TargetTemperature*100))
+	b.WriteByte(valve)
+	b.Write([]byte{0x00, 0x00})
+}
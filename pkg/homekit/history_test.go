@@ -0,0 +1,112 @@
+package homekit
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/brutella/hap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ivanvanderbyl/escea-fireplace/pkg/firecontrol"
+)
+
+// readEntries requests the history from entry from, the way the Eve app does,
+// and returns every batch of entries it is sent.
+func readEntries(t *testing.T, h *history, from uint32) [][]byte {
+	t.Helper()
+
+	request := []byte{0x01, 0x14, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(request[2:6], from)
+	req := httptest.NewRequest("PUT", "/characteristics", nil)
+	_, code := h.request.SetValueRequest(base64.StdEncoding.EncodeToString(request), req)
+	require.Equal(t, hapStatusSuccess, code)
+
+	var batches [][]byte
+	for {
+		v, code := h.entries.ValueRequest(req)
+		require.Equal(t, hapStatusSuccess, code)
+		b, err := base64.StdEncoding.DecodeString(v.(string))
+		require.NoError(t, err)
+		if len(b) == 1 && b[0] == 0 {
+			return batches
+		}
+		batches = append(batches, b)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	a := assert.New(t)
+	st := hap.NewFsStore(t.TempDir())
+
+	h, err := newHistory(st, "history")
+	require.NoError(t, err)
+	a.Empty(readEntries(t, h, 0))
+
+	start := time.Unix(eveEpoch+1000, 0)
+	require.NoError(t, h.record(start, &firecontrol.Status{IsOn: true, CurrentTemperature: 18, TargetTempertaure: 22}))
+	require.NoError(t, h.record(start.Add(time.Minute), &firecontrol.Status{CurrentTemperature: 30}))
+	require.NoError(t, h.record(start.Add(historyInterval), &firecontrol.Status{CurrentTemperature: 19, TargetTempertaure: 22}))
+
+	status := h.status.Value()
+	a.Equal(uint32(600), binary.LittleEndian.Uint32(status[0:4]), "time of the last entry")
+	a.Equal(uint32(1000), binary.LittleEndian.Uint32(status[8:12]), "reference time")
+	a.Equal(eveThermoSignature, status[12:23])
+	a.Equal(uint16(3), binary.LittleEndian.Uint16(status[23:25]), "entries, including the reference entry")
+
+	batches := readEntries(t, h, 1)
+	require.Len(t, batches, 1)
+	b := batches[0]
+
+	// The reference entry, then a sample taken when on and one when off.
+	require.Len(t, b, 21+17+17)
+	a.Equal(byte(0x81), b[9])
+	a.Equal(uint32(1000), binary.LittleEndian.Uint32(b[10:14]))
+
+	sample := b[21:38]
+	a.Equal(uint32(2), binary.LittleEndian.Uint32(sample[1:5]), "entry number")
+	a.Equal(uint32(0), binary.LittleEndian.Uint32(sample[5:9]), "seconds since the reference time")
+	a.Equal(uint16(1800), binary.LittleEndian.Uint16(sample[10:12]), "room temperature")
+	a.Equal(uint16(2200), binary.LittleEndian.Uint16(sample[12:14]), "target temperature")
+	a.Equal(byte(100), sample[14], "power")
+
+	sample = b[38:]
+	a.Equal(uint32(3), binary.LittleEndian.Uint32(sample[1:5]))
+	a.Equal(uint32(600), binary.LittleEndian.Uint32(sample[5:9]))
+	a.Equal(byte(0), sample[14])
+
+	// The Eve app asks again from the last entry it has.
+	batches = readEntries(t, h, 3)
+	require.Len(t, batches, 1)
+	a.Len(batches[0], 17)
+
+	// Samples are kept across restarts.
+	reloaded, err := newHistory(st, "history")
+	require.NoError(t, err)
+	a.Equal(h.data, reloaded.data)
+	a.Equal(status, reloaded.status.Value())
+}
+
+func TestHistoryRollsOver(t *testing.T) {
+	a := assert.New(t)
+
+	h, err := newHistory(hap.NewFsStore(t.TempDir()), "history")
+	require.NoError(t, err)
+
+	start := time.Unix(eveEpoch, 0)
+	for i := 0; i < historySize+10; i++ {
+		h.data.RefTime = 1
+		h.add(historyEntry{Time: start.Add(time.Duration(i) * historyInterval).Unix()})
+	}
+	a.Len(h.data.Entries, historySize)
+	a.Equal(uint32(10), h.firstEntry())
+
+	// Entries that were dropped are skipped, starting from a reference entry.
+	batches := readEntries(t, h, 1)
+	a.Len(batches, (historySize+historyBatch-1)/historyBatch)
+	a.Equal(uint32(11), binary.LittleEndian.Uint32(batches[0][1:5]))
+	a.Equal(byte(0x81), batches[0][9])
+}
@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package homekit
+
+import "os"
+
+func lockStore(dir string) (*os.File, error) {
+	return nil, nil
+}
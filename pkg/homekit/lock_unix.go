@@ -0,0 +1,33 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package homekit
+
+import (
+	stderrors "errors"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// lockStore takes an exclusive lock on the HomeKit store in dir, returning
+// ErrStoreInUse if another process holds it. The lock is released when the
+// returned file is closed or the process exits.
+func lockStore(dir string) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Join(dir, storeLockFile), os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, errors.Wrap(err, "locking HomeKit store")
+	}
+
+	err = unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if stderrors.Is(err, unix.EWOULDBLOCK) {
+		f.Close()
+		return nil, errors.Wrap(ErrStoreInUse, dir)
+	}
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "locking HomeKit store")
+	}
+	return f, nil
+}
@@ -0,0 +1,136 @@
+package homekit
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	stderrors "errors"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/brutella/hap"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrStoreInUse is returned when another process, usually a running
+	// HomeKit server, holds the store.
+	ErrStoreInUse = stderrors.New("HomeKit store is in use by a running server")
+
+	// ErrPairingNotFound is returned when removing a pairing that does not
+	// exist.
+	ErrPairingNotFound = stderrors.New("pairing not found")
+)
+
+// pairingSuffix is the suffix of the keys hap stores pairings under, the key
+// being the hex encoded name of the controller.
+const pairingSuffix = ".pairing"
+
+// storeLockFile is the file in the store locked by whoever is using it.
+const storeLockFile = ".lock"
+
+// hapPermissionAdmin marks a controller that can add and remove pairings.
+const hapPermissionAdmin = 0x01
+
+// Pairing is a controller, such as an iPhone or home hub, paired with the
+// accessory.
+type Pairing struct {
+	Name      string
+	PublicKey []byte
+	Admin     bool
+}
+
+// PairingStore manages the pairings in a HomeKit store. It holds the store's
+// lock until closed, so a server cannot start using it in the meantime.
+type PairingStore struct {
+	dir   string
+	store hap.Store
+	lock  *os.File
+}
+
+// OpenPairingStore opens the HomeKit store in dir, ./db if empty. It fails with
+// ErrStoreInUse if a server holds the store, unless force is set.
+func OpenPairingStore(dir string, force bool) (*PairingStore, error) {
+	if dir == "" {
+		dir = defaultStorageDir
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil, errors.Wrap(err, "opening HomeKit store")
+	}
+
+	lock, err := lockStore(dir)
+	if stderrors.Is(err, ErrStoreInUse) && force {
+		lock, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &PairingStore{dir: dir, store: hap.NewFsStore(dir), lock: lock}, nil
+}
+
+// Close releases the store.
+func (s *PairingStore) Close() error {
+	if s.lock == nil {
+		return nil
+	}
+	return s.lock.Close()
+}
+
+// List returns the pairings sorted by name.
+func (s *PairingStore) List() ([]Pairing, error) {
+	keys, err := s.store.KeysWithSuffix(pairingSuffix)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing pairings")
+	}
+
+	pairings := make([]Pairing, 0, len(keys))
+	for _, key := range keys {
+		b, err := s.store.Get(key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading pairing %s", key)
+		}
+		var p hap.Pairing
+		if err := json.Unmarshal(b, &p); err != nil {
+			return nil, errors.Wrapf(err, "reading pairing %s", key)
+		}
+		pairings = append(pairings, Pairing{
+			Name:      p.Name,
+			PublicKey: p.PublicKey,
+			Admin:     p.Permission&hapPermissionAdmin != 0,
+		})
+	}
+
+	sort.Slice(pairings, func(i, j int) bool { return pairings[i].Name < pairings[j].Name })
+	return pairings, nil
+}
+
+// Remove removes the pairing with the given name.
+func (s *PairingStore) Remove(name string) error {
+	err := s.store.Delete(hex.EncodeToString([]byte(name)) + pairingSuffix)
+	if stderrors.Is(err, os.ErrNotExist) {
+		return errors.Wrap(ErrPairingNotFound, name)
+	}
+	return errors.Wrapf(err, "removing pairing %s", name)
+}
+
+// Reset removes every pairing along with the accessory's keys and identity, so
+// the next server started on the store can be added to the Home app as a new
+// accessory. FireControl's own data is kept: the setup code and setup ID, so
+// printed codes stay valid, and the history.
+func (s *PairingStore) Reset() error {
+	keys, err := s.store.KeysWithSuffix("")
+	if err != nil {
+		return errors.Wrap(err, "resetting HomeKit store")
+	}
+
+	for _, key := range keys {
+		if strings.HasPrefix(key, firecontrolKeyPrefix) || strings.HasPrefix(key, ".") {
+			continue
+		}
+		if err := s.store.Delete(key); err != nil {
+			return errors.Wrapf(err, "resetting HomeKit store")
+		}
+	}
+	return nil
+}
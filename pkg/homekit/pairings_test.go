@@ -0,0 +1,75 @@
+package homekit
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/brutella/hap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func savePairing(t *testing.T, st hap.Store, p hap.Pairing) {
+	b, err := json.Marshal(p)
+	require.NoError(t, err)
+	require.NoError(t, st.Set(hex.EncodeToString([]byte(p.Name))+pairingSuffix, b))
+}
+
+func TestPairingStore(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+
+	st := hap.NewFsStore(dir)
+	savePairing(t, st, hap.Pairing{Name: "phone", PublicKey: []byte{1}, Permission: hapPermissionAdmin})
+	savePairing(t, st, hap.Pairing{Name: "hub", PublicKey: []byte{2}})
+	require.NoError(t, st.Set("keypair", []byte("{}")))
+	code, err := persistedSetupCode(st)
+	require.NoError(t, err)
+
+	store, err := OpenPairingStore(dir, false)
+	require.NoError(t, err)
+	defer store.Close()
+
+	pairings, err := store.List()
+	require.NoError(t, err)
+	a.Equal([]Pairing{
+		{Name: "hub", PublicKey: []byte{2}},
+		{Name: "phone", PublicKey: []byte{1}, Admin: true},
+	}, pairings)
+
+	a.ErrorIs(store.Remove("tablet"), ErrPairingNotFound)
+	a.NoError(store.Remove("hub"))
+	pairings, err = store.List()
+	require.NoError(t, err)
+	a.Len(pairings, 1)
+
+	a.NoError(store.Reset())
+	pairings, err = store.List()
+	require.NoError(t, err)
+	a.Empty(pairings)
+	_, err = st.Get("keypair")
+	a.Error(err, "the accessory's keys are removed")
+	kept, err := persistedSetupCode(st)
+	require.NoError(t, err)
+	a.Equal(code, kept, "the setup code is kept")
+}
+
+func TestPairingStoreInUse(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+
+	lock, err := lockStore(dir)
+	require.NoError(t, err)
+	if lock == nil {
+		t.Skip("stores are not locked on this platform")
+	}
+	defer lock.Close()
+
+	_, err = OpenPairingStore(dir, false)
+	a.ErrorIs(err, ErrStoreInUse)
+
+	store, err := OpenPairingStore(dir, true)
+	require.NoError(t, err)
+	a.NoError(store.Close())
+}
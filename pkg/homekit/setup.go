@@ -0,0 +1,154 @@
+package homekit
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/brutella/hap"
+	"github.com/mdp/qrterminal/v3"
+	"github.com/pkg/errors"
+	"rsc.io/qr"
+)
+
+// firecontrolKeyPrefix is the prefix of the keys FireControl keeps its own data
+// under in the HAP store.
+const firecontrolKeyPrefix = "firecontrol-"
+
+// Keys the generated setup code and setup ID are persisted under in the HAP
+// store, so the code printed on first run keeps working.
+const (
+	setupCodeKey = firecontrolKeyPrefix + "setup-code"
+	setupIDKey   = firecontrolKeyPrefix + "setup-id"
+)
+
+// setupIDChars are the characters a setup ID is made of.
+const setupIDChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// hapCategoryShift and hapFlagIP place the accessory category and the "pairs
+// over IP" flag in the setup payload encoded in a setup URI.
+const (
+	hapCategoryShift = 31
+	hapFlagIP        = 1 << 28
+)
+
+// normalizeSetupCode accepts a setup code with or without dashes, as printed on
+// HomeKit labels, and returns its 8 digits.
+func normalizeSetupCode(code string) (string, error) {
+	digits := strings.ReplaceAll(code, "-", "")
+	if len(digits) != 8 {
+		return "", errors.Errorf("setup code %q must have 8 digits", code)
+	}
+	if _, err := strconv.ParseUint(digits, 10, 32); err != nil {
+		return "", errors.Errorf("setup code %q must only contain digits", code)
+	}
+	if hap.InvalidPins[digits] {
+		return "", errors.Errorf("setup code %q is too easy to guess", code)
+	}
+	return digits, nil
+}
+
+// persistedSetupCode returns the setup code stored in st, generating and
+// storing a random one if there is none.
+func persistedSetupCode(st hap.Store) (string, error) {
+	return persisted(st, setupCodeKey, func() (string, error) {
+		for {
+			n, err := rand.Int(rand.Reader, big.NewInt(100_000_000))
+			if err != nil {
+				return "", err
+			}
+			code := fmt.Sprintf("%08d", n.Int64())
+			if !hap.InvalidPins[code] {
+				return code, nil
+			}
+		}
+	})
+}
+
+// persistedSetupID returns the setup ID stored in st, generating and storing a
+// random one if there is none.
+func persistedSetupID(st hap.Store) (string, error) {
+	return persisted(st, setupIDKey, func() (string, error) {
+		id := make([]byte, 4)
+		for i := range id {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(setupIDChars))))
+			if err != nil {
+				return "", err
+			}
+			id[i] = setupIDChars[n.Int64()]
+		}
+		return string(id), nil
+	})
+}
+
+func persisted(st hap.Store, key string, generate func() (string, error)) (string, error) {
+	if b, err := st.Get(key); err == nil && len(b) > 0 {
+		return string(b), nil
+	}
+
+	value, err := generate()
+	if err != nil {
+		return "", errors.Wrapf(err, "generating %s", key)
+	}
+	if err := st.Set(key, []byte(value)); err != nil {
+		return "", errors.Wrapf(err, "storing %s", key)
+	}
+	return value, nil
+}
+
+// setupURI returns the X-HM:// URI encoded in HomeKit pairing QR codes for an
+// accessory of the given category.
+func setupURI(category byte, code, setupID string) string {
+	n, _ := strconv.ParseUint(code, 10, 32)
+	payload := uint64(category)<<hapCategoryShift | hapFlagIP | n
+
+	encoded := strings.ToUpper(strconv.FormatUint(payload, 36))
+	if len(encoded) < 9 {
+		encoded = strings.Repeat("0", 9-len(encoded)) + encoded
+	}
+	return "X-HM://" + encoded + setupID
+}
+
+// formatSetupCode formats an 8 digit setup code the way HomeKit shows it.
+func formatSetupCode(code string) string {
+	return code[:3] + "-" + code[3:5] + "-" + code[5:]
+}
+
+// printSetup writes a QR code and the setup code for pairing to w.
+func printSetup(w io.Writer, name string, category byte, code, setupID string) {
+	uri := setupURI(category, code, setupID)
+	fmt.Fprintf(w, "Scan this code with the Home app to add %s:\n\n", name)
+	qrterminal.GenerateHalfBlock(uri, qr.L, w)
+	fmt.Fprintf(w, "\nOr enter the setup code %s (%s)\n\n", formatSetupCode(code), uri)
+}
+
+// listenAddr returns the address the HomeKit server listens on: the first IPv4
+// address of the named interface, or every interface if it is empty. A zero
+// port picks a free one.
+func listenAddr(iface string, port int) (string, error) {
+	host := ""
+	if iface != "" {
+		ifi, err := net.InterfaceByName(iface)
+		if err != nil {
+			return "", errors.Wrapf(err, "interface %s", iface)
+		}
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			return "", errors.Wrapf(err, "interface %s", iface)
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+				host = ipnet.IP.String()
+				break
+			}
+		}
+		if host == "" {
+			return "", errors.Errorf("interface %s has no IPv4 address", iface)
+		}
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
@@ -0,0 +1,70 @@
+package homekit
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/brutella/hap"
+	"github.com/brutella/hap/accessory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeSetupCode(t *testing.T) {
+	a := assert.New(t)
+
+	code, err := normalizeSetupCode("314-15-926")
+	a.NoError(err)
+	a.Equal("31415926", code)
+
+	code, err = normalizeSetupCode("31415926")
+	a.NoError(err)
+	a.Equal("31415926", code)
+
+	for _, invalid := range []string{"1234", "314-15-92a", "123-45-678", "000-00-000"} {
+		_, err := normalizeSetupCode(invalid)
+		a.Error(err, invalid)
+	}
+}
+
+func TestPersistedSetupCode(t *testing.T) {
+	a := assert.New(t)
+	st := hap.NewFsStore(t.TempDir())
+
+	code, err := persistedSetupCode(st)
+	require.NoError(t, err)
+	a.Len(code, 8)
+	a.False(hap.InvalidPins[code])
+
+	again, err := persistedSetupCode(st)
+	require.NoError(t, err)
+	a.Equal(code, again, "the generated code is kept")
+
+	id, err := persistedSetupID(st)
+	require.NoError(t, err)
+	a.Regexp(`^[0-9A-Z]{4}$`, id)
+
+	again, err = persistedSetupID(st)
+	require.NoError(t, err)
+	a.Equal(id, again, "the generated setup ID is kept")
+}
+
+func TestSetupURI(t *testing.T) {
+	a := assert.New(t)
+
+	uri := setupURI(accessory.TypeBridge, "31415926", "AB12")
+	a.True(strings.HasPrefix(uri, "X-HM://"))
+	a.True(strings.HasSuffix(uri, "AB12"))
+
+	encoded := strings.TrimSuffix(strings.TrimPrefix(uri, "X-HM://"), "AB12")
+	a.Len(encoded, 9)
+
+	payload, err := strconv.ParseUint(encoded, 36, 64)
+	require.NoError(t, err)
+	a.Equal(uint64(31415926), payload&(1<<27-1), "setup code")
+	a.Equal(uint64(hapFlagIP), payload&hapFlagIP, "IP flag")
+	a.Equal(uint64(accessory.TypeBridge), payload>>hapCategoryShift, "category")
+
+	a.Equal("314-15-926", formatSetupCode("31415926"))
+}